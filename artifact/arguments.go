@@ -0,0 +1,126 @@
+package artifact
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// isArgType tells if command line arguments can be converted
+// into a parameter of type t
+func isArgType(t reflect.Type) bool {
+	if t == durationType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// paramTypes returns the parameter types of a method value, the
+// last one is the element type if the method is variadic
+func paramTypes(t reflect.Type) []reflect.Type {
+	var res []reflect.Type
+	for i := 0; i < t.NumIn(); i++ {
+		pt := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			pt = pt.Elem()
+		}
+		res = append(res, pt)
+	}
+	return res
+}
+
+// paramList describes the parameters of a method value,
+// like "(string, ...int)"
+func paramList(t reflect.Type) string {
+	var ps []string
+	for i, pt := range paramTypes(t) {
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			ps = append(ps, "..."+pt.String())
+		} else {
+			ps = append(ps, pt.String())
+		}
+	}
+	return "(" + strings.Join(ps, ", ") + ")"
+}
+
+// convertArgs converts the command line arguments to the parameters
+// of the method value m
+func convertArgs(meth string, m reflect.Value, args []string) ([]reflect.Value, error) {
+	t := m.Type()
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	}
+	if len(args) < fixed || (!t.IsVariadic() && len(args) > fixed) {
+		return nil, fmt.Errorf("%s%s called with %d argument(s)", meth, paramList(t), len(args))
+	}
+	pts := paramTypes(t)
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		pt := pts[len(pts)-1]
+		if i < fixed {
+			pt = pts[i]
+		}
+		v, err := parseArg(arg, pt)
+		if err != nil {
+			return nil, fmt.Errorf("%s%s argument %d: %v", meth, paramList(t), i+1, err)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
+
+// parseArg converts the argument to a value of type t
+func parseArg(arg string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if t == durationType {
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return v, fmt.Errorf("%q is not a duration", arg)
+		}
+		v.SetInt(int64(d))
+		return v, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(arg)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(arg)
+		if err != nil {
+			return v, fmt.Errorf("%q is not a %s", arg, t)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(arg, 0, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("%q is not a %s", arg, t)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(arg, 0, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("%q is not a %s", arg, t)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(arg, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("%q is not a %s", arg, t)
+		}
+		v.SetFloat(f)
+	default:
+		return v, fmt.Errorf("unsupported parameter type %s", t)
+	}
+	return v, nil
+}
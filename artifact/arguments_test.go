@@ -0,0 +1,63 @@
+package artifact
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Typed struct{ BaseArtifact }
+
+func (t *Typed) Scale(n int, ratio float64, verbose bool, wait time.Duration) {}
+func (t *Typed) Tag(name string, sizes ...uint8)                              {}
+func (t *Typed) Clean()                                                       {}
+
+func TestConvertArgs(t *testing.T) {
+	a := reflect.ValueOf(new(Typed))
+	tests := []struct {
+		meth string
+		args []string
+		want []interface{} // the converted arguments
+		err  string        // the error, if the conversion fails
+	}{
+		{"Scale", []string{"-3", "0.5", "true", "1m30s"}, []interface{}{-3, 0.5, true, 90 * time.Second}, ""},
+		{"Scale", []string{"0x10", "2", "0", "0s"}, []interface{}{16, 2.0, false, time.Duration(0)}, ""},
+		{"Tag", []string{"v1"}, []interface{}{"v1"}, ""},
+		{"Tag", []string{"v1", "8", "255"}, []interface{}{"v1", uint8(8), uint8(255)}, ""},
+		{"Clean", nil, []interface{}{}, ""},
+		// Too few or too many arguments
+		{"Scale", []string{"1", "0.5", "true"}, nil,
+			"Scale(int, float64, bool, time.Duration) called with 3 argument(s)"},
+		{"Tag", nil, nil, "Tag(string, ...uint8) called with 0 argument(s)"},
+		{"Clean", []string{"all"}, nil, "Clean() called with 1 argument(s)"},
+		// Arguments that do not convert
+		{"Scale", []string{"three", "0.5", "true", "1s"}, nil,
+			`Scale(int, float64, bool, time.Duration) argument 1: "three" is not a int`},
+		{"Scale", []string{"3", "half", "true", "1s"}, nil, `argument 2: "half" is not a float64`},
+		{"Scale", []string{"3", "0.5", "yes", "1s"}, nil, `argument 3: "yes" is not a bool`},
+		{"Scale", []string{"3", "0.5", "true", "soon"}, nil, `argument 4: "soon" is not a duration`},
+		{"Tag", []string{"v1", "8", "256"}, nil, `Tag(string, ...uint8) argument 3: "256" is not a uint8`},
+		{"Tag", []string{"v1", "-1"}, nil, `argument 2: "-1" is not a uint8`},
+	}
+	for _, tt := range tests {
+		in, err := convertArgs(tt.meth, a.MethodByName(tt.meth), tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s%q: error %v, want %q", tt.meth, tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s%q: %v", tt.meth, tt.args, err)
+			continue
+		}
+		got := []interface{}{}
+		for _, v := range in {
+			got = append(got, v.Interface())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s%q converted to %#v, want %#v", tt.meth, tt.args, got, tt.want)
+		}
+	}
+}
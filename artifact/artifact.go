@@ -1,8 +1,9 @@
 package artifact
 
 import (
-	"log"
+	"fmt"
 	"reflect"
+	"sort"
 )
 
 // An Artifact identifies a product of the build system. It can be
@@ -29,9 +30,9 @@ func (b *BaseArtifact) SetID(str string) {
 	b.id = str
 }
 
-// Usage of the artifact
+// Usage of the artifact, empty unless overridden. Commands
+// are described with Describe.
 func (b *BaseArtifact) Usage() string {
-	log.Print("Usage not implemented.")
 	return ""
 }
 
@@ -45,29 +46,132 @@ func (b *BaseArtifact) ID() string {
 func (b *BaseArtifact) CheckConfiguration() {
 }
 
+// Describe attaches a description to cmd, shown by help
+// Describe("AMBuilder.Configure", "Runs /src/configure in the /build dir")
+func (r *Registry) Describe(cmd string, description string) {
+	r.descriptions[cmd] = description
+}
+
+// Description returns the description attached to cmd, or to
+// a name resolving to the same command
+func (r *Registry) Description(cmd string) string {
+	if d, ok := r.descriptions[cmd]; ok {
+		return d
+	}
+	var declared []string
+	for d := range r.descriptions {
+		declared = append(declared, d)
+	}
+	sort.Strings(declared)
+	for _, d := range r.sameCommand(r.canonical(cmd), declared) {
+		return r.descriptions[d]
+	}
+	return ""
+}
+
+// Describe attaches a description to cmd, shown by help
+func Describe(cmd string, description string) {
+	Default.Describe(cmd, description)
+}
+
+// Description returns the description attached to cmd
+func Description(cmd string) string {
+	return Default.Description(cmd)
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// isCommand tells if the method can be called as a command. Commands
+// return nothing or an error, take parameters that can be parsed from
+// command line arguments, and are not part of the Artifact interface
+// or the BaseArtifact.
+func isCommand(m reflect.Method) bool {
+	if _, ok := reflect.TypeOf((*Artifact)(nil)).Elem().MethodByName(m.Name); ok {
+		return false
+	}
+	if _, ok := reflect.TypeOf(&BaseArtifact{}).MethodByName(m.Name); ok {
+		return false
+	}
+	// The first parameter is the receiver
+	return isCommandFunc(m.Type, 1)
+}
+
+// isCommandFunc tells if a function of type t, with the
+// parameters from first on, can be called as a command
+func isCommandFunc(t reflect.Type, first int) bool {
+	for i := first; i < t.NumIn(); i++ {
+		pt := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			pt = pt.Elem()
+		}
+		if !isArgType(pt) {
+			return false
+		}
+	}
+	switch t.NumOut() {
+	case 0:
+		return true
+	case 1:
+		return t.Out(0) == errorType
+	}
+	return false
+}
+
 // GetCommands returns a list of valid commands on the artifact
 func GetCommands(a Artifact) []string {
 	var res []string
 	val := reflect.ValueOf(a).Type()
 	for n := 0; n < val.NumMethod(); n++ {
-		res = append(res, val.Method(n).Name)
+		if isCommand(val.Method(n)) {
+			res = append(res, val.Method(n).Name)
+		}
 	}
 	return res
 }
 
-// CallCmd calls a.meth(args)
-func CallCmd(a *Artifact, meth string, args ...string) {
-	inputs := make([]reflect.Value, 1)
-	inputs[0] = reflect.ValueOf(args)
+func hasCommand(a Artifact, cmd string) bool {
+	for _, c := range GetCommands(a) {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// CallCmd calls a.meth(args). The arguments are converted to the
+// parameter types of the method, which may be variadic. If the
+// arguments do not match, or the command returns an error or
+// panics, the error is returned.
+func CallCmd(a *Artifact, meth string, args ...string) (err error) {
 	m := reflect.ValueOf(*a).MethodByName(meth)
-	m.Call(nil)
-	//	reflect.ValueOf(*a).MethodByName(meth).CallSlice(inputs)
+	if !m.IsValid() {
+		return fmt.Errorf("no command %s", meth)
+	}
+	inputs, err := convertArgs(meth, m, args)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s panicked: %v", meth, r)
+		}
+	}()
+	out := m.Call(inputs)
+	if len(out) == 1 && !out[0].IsNil() {
+		return out[0].Interface().(error)
+	}
+	return nil
 }
 
 // RegisterConfigurationInterest will loop through all artifacts and let them
 // tell what configurations they are interested in.
-func RegisterConfigurationInterest() {
-	for _, a := range arties {
+func (r *Registry) RegisterConfigurationInterest() {
+	for _, a := range r.All() {
 		a.CheckConfiguration()
 	}
 }
+
+// RegisterConfigurationInterest of the artifacts in the default registry
+func RegisterConfigurationInterest() {
+	Default.RegisterConfigurationInterest()
+}
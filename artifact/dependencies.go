@@ -2,116 +2,331 @@ package artifact
 
 import (
 	"flag"
+	"fmt"
 	"log"
-	"os"
-	"path/filepath"
-	"reflect"
-
+	"runtime"
+	"sort"
 	"strings"
-
-	"github.com/staffano/crazy-build/workspace"
 )
 
-// IgnoreStamps if true stamps folder will be ignored
-var IgnoreStamps bool
+// A dependency is an edge in the dependency graph
+type dependency struct {
+	cmd     string
+	loc     string // source location of the Depends call declaring it
+	implied bool   // implied by input and output files
+}
 
-// dependencies between commands
-var dependencies map[string][]string
+// Depends declares dependencies for arty (artifact:cmd)
+// Depends("AMBuild.Compile", "AMBuild.Configure", "AMBuild.Verify")
+func (r *Registry) Depends(arty string, deps ...string) {
+	r.addDependencies(arty, caller(1), deps...)
+}
 
-// isDone checks if a command already is executed
-func isDone(cmd string) bool {
-	if IgnoreStamps {
-		return false
-	}
-	path := filepath.Join(workspace.GetStampDirPath(), cmd)
-	if _, err := os.Stat(path); err == nil {
-		return true
-	}
-	return false
+// DependsOn declares dependencies using method values of the artifacts.
+// It panics if any of the values is not a command of an artifact type.
+// DependsOn(a.Print2, a.Print)
+func (r *Registry) DependsOn(cmd interface{}, deps ...interface{}) {
+	r.dependsOn(caller(1), cmd, deps...)
 }
 
-// Mark a command as done in the stamp dir
-func markDone(cmd string) {
-	if IgnoreStamps {
-		return
-	}
-	sd := workspace.GetStampDirPath()
-	f := filepath.Join(sd, cmd)
-	ff, err := os.OpenFile(f, os.O_RDONLY|os.O_CREATE, 0666)
-	defer ff.Close()
+// Depends declares dependencies in the default registry
+func Depends(arty string, deps ...string) {
+	Default.addDependencies(arty, caller(1), deps...)
+}
+
+// DependsOn declares dependencies in the default registry,
+// using method values of the artifacts
+func DependsOn(cmd interface{}, deps ...interface{}) {
+	Default.dependsOn(caller(1), cmd, deps...)
+}
+
+func (r *Registry) dependsOn(loc string, cmd interface{}, deps ...interface{}) {
+	name, err := commandName(cmd)
 	if err != nil {
-		log.Fatalf("Error creating stamp file %s : %v", f, err)
+		panic(fmt.Sprintf("DependsOn at %s: %v", loc, err))
+	}
+	var names []string
+	for _, d := range deps {
+		dn, err := commandName(d)
+		if err != nil {
+			panic(fmt.Sprintf("DependsOn at %s: %v", loc, err))
+		}
+		names = append(names, dn)
 	}
+	r.addDependencies(name, loc, names...)
 }
 
-// Depends declares dependencies for arty (artifact:cmd)
-// Depends("AMBuild.Compile", "AMBuild.Configure", "AMBuild.Verify")
-func Depends(arty string, deps ...string) {
-	_, ok := dependencies[arty]
+func (r *Registry) addDependencies(arty string, loc string, deps ...string) {
+	_, ok := r.dependencies[arty]
 	if !ok {
-		dependencies[arty] = make([]string, 0, 20)
+		r.dependencies[arty] = make([]dependency, 0, 20)
 	}
 	for _, s := range deps {
-		dependencies[arty] = append(dependencies[arty], s)
+		r.dependencies[arty] = append(r.dependencies[arty], dependency{cmd: s, loc: loc})
 	}
 }
 
-// Call the command by using introspection.
-// Example artifact.Call("AMBuilder:Instantiate") will first call all dependencies
-// then make sure used services are started and then call AMBuilder.Instantiate()
-// The services are associated with the artifact using dependency injection
-func Call(cmd string) {
+// caller returns the source location of the caller, skip
+// levels up from the function calling caller
+func caller(skip int) string {
+	if _, file, line, ok := runtime.Caller(skip + 1); ok {
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return "unknown location"
+}
 
-	if isDone(cmd) {
-		log.Printf("%s already done, skipping...", cmd)
-		return
+// edges returns all dependencies of cmd, both the declared
+// ones and the ones implied by input and output files. cmd is
+// canonical, the declarations of any name resolving to it count.
+func (r *Registry) edges(cmd string) []dependency {
+	var declared []string
+	for d := range r.dependencies {
+		declared = append(declared, d)
 	}
-	cs := strings.Split(cmd, ".")
-	a := Find(cs[0])
-	if len(a) == 0 {
-		log.Fatal("Artifact not found")
+	sort.Strings(declared)
+	var res []dependency
+	for _, d := range r.sameCommand(cmd, declared) {
+		for _, dep := range r.dependencies[d] {
+			dep.cmd = r.canonical(dep.cmd)
+			res = append(res, dep)
+		}
 	}
-	cmds := GetCommands(*a[0])
-	if len(cmds) == 0 {
-		log.Fatal("No commands for artifact")
+	return append(res, r.fileEdges(cmd)...)
+}
+
+// Dependencies returns the commands cmd depends on, both the declared
+// ones and the ones writing files that cmd reads
+func (r *Registry) Dependencies(cmd string) []string {
+	var res []string
+	for _, d := range r.edges(r.canonical(cmd)) {
+		res = append(res, d.cmd)
 	}
+	return res
+}
 
-	// First recursively call any non-complete dependencies
-	deps, ok := dependencies[cmd]
-	if ok {
-		for _, dep := range deps {
-			Call(dep)
-		}
+// Dependencies returns the commands cmd depends on in the default registry
+func Dependencies(cmd string) []string {
+	return Default.Dependencies(cmd)
+}
+
+// A CycleError reports a cycle among the declared dependencies
+type CycleError struct {
+	// Chain of commands forming the cycle, the first and last are the same
+	Chain []string
+	// Locations[i] is where Chain[i] was declared to depend on Chain[i+1]
+	Locations []string
+}
+
+func (e *CycleError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "dependency cycle: %s", strings.Join(e.Chain, " -> "))
+	for i, loc := range e.Locations {
+		fmt.Fprintf(&b, "\n\t%s -> %s declared at %s", e.Chain[i], e.Chain[i+1], loc)
 	}
+	return b.String()
+}
 
-	// Resolve Service dependencies
-	artifactValue := reflect.ValueOf(*a[0]).Elem()
-	t := artifactValue.Type()
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		if req, ok := field.Tag.Lookup("requirement"); ok {
-			for _, s := range services {
-				// The pointer to the instance could Implement
-				// the service interface. Why?
-				serviceType := reflect.ValueOf(*s).Type()
-				if serviceType.Implements(field.Type) {
-					if (*s).Satisfies(req) && (*s).IsAvailable() {
-						artifactValue.Field(i).Set(reflect.ValueOf(*s))
-					}
-				}
+// CheckDependencies verifies that the declared dependencies
+// do not contain any cycles
+func (r *Registry) CheckDependencies() error {
+	var cmds []string
+	for cmd := range r.dependencies {
+		cmds = append(cmds, r.canonical(cmd))
+	}
+	for cmd := range r.inputFiles {
+		cmds = append(cmds, r.canonical(cmd))
+	}
+	sort.Strings(cmds)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
+	var locs []string
+	var visit func(cmd string) error
+	visit = func(cmd string) error {
+		switch state[cmd] {
+		case visited:
+			return nil
+		case visiting:
+			// Cut out the part of the stack that forms the cycle
+			i := len(stack) - 1
+			for stack[i] != cmd {
+				i--
+			}
+			chain := append(append([]string{}, stack[i:]...), cmd)
+			return &CycleError{Chain: chain, Locations: append([]string{}, locs[i:]...)}
+		}
+		state[cmd] = visiting
+		stack = append(stack, cmd)
+		for _, dep := range r.edges(cmd) {
+			locs = append(locs, dep.loc)
+			if err := visit(dep.cmd); err != nil {
+				return err
 			}
+			locs = locs[:len(locs)-1]
+		}
+		stack = stack[:len(stack)-1]
+		state[cmd] = visited
+		return nil
+	}
+	for _, cmd := range cmds {
+		if err := visit(cmd); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// CheckDependencies verifies the dependencies declared
+// in the default registry
+func CheckDependencies() error {
+	return Default.CheckDependencies()
+}
+
+// Call the commands by using introspection.
+// Example artifact.Call("AMBuilder.Instantiate") will first call all dependencies
+// then make sure used services are started and then call AMBuilder.Instantiate()
+// The services are associated with the artifact using dependency injection.
+// Commands that do not depend on each other are executed in parallel,
+// using at most r.Jobs workers. When a command fails, the commands depending
+// on it are not executed, while unrelated commands still are. The returned
+// error is a *BuildError summarizing all failures.
+func (r *Registry) Call(cmds ...string) error {
+	return r.CallWithArgs(nil, cmds...)
+}
+
+// CallWithArgs calls the commands like Call, passing args to each
+// of the commands. The dependencies are called without arguments.
+// CallWithArgs([]string{"--host=arm"}, "AMBuilder.Configure")
+func (r *Registry) CallWithArgs(args []string, cmds ...string) error {
+	if err := r.CheckDependencies(); err != nil {
+		return err
+	}
+	// Shared services are used by the commands until the build is done
+	defer r.pool.releaseShared()
+	return r.newPlan(args, cmds...).execute(r.Jobs)
+}
+
+// StampStatus tells, for each of the commands, if it is stamped
+// as done with the signature it would be executed with now
+func (r *Registry) StampStatus(cmds ...string) (map[string]bool, error) {
+	if err := r.CheckDependencies(); err != nil {
+		return nil, err
+	}
+	p := r.newPlan(nil, cmds...)
+	res := make(map[string]bool)
+	for _, n := range p.order {
+		n.sig = r.signature(n)
+		res[n.cmd] = r.isDone(n.cmd, n.sig)
+	}
+	return res, nil
+}
+
+// Call the commands of the default registry, see Registry.Call
+func Call(cmds ...string) error {
+	return Default.CallWithArgs(nil, cmds...)
+}
+
+// CallWithArgs calls the commands of the default registry
+// with args, see Registry.CallWithArgs
+func CallWithArgs(args []string, cmds ...string) error {
+	return Default.CallWithArgs(args, cmds...)
+}
+
+// StampStatus tells, for each of the commands of the default
+// registry, if it is stamped as done
+func StampStatus(cmds ...string) (map[string]bool, error) {
+	return Default.StampStatus(cmds...)
+}
+
+// lookup finds the artifact and the command name of cmd
+func (r *Registry) lookup(cmd string) (Artifact, string, error) {
+	name, meth, err := splitCommand(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	a, err := r.Resolve(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if !hasCommand(a, meth) {
+		return nil, "", fmt.Errorf("artifact %s has no command %q", Name(a), meth)
+	}
+	return a, meth, nil
+}
+
+// decide tells if the command of the node needs to be executed, and
+// why. The signature of the node must already be calculated.
+func (r *Registry) decide(n *node) (bool, string) {
+	if r.IgnoreStamps {
+		return true, "stamps are ignored"
+	}
+	stamp, stamped := r.readStamp(n.cmd)
+	switch {
+	case stamped && stamp == n.sig:
+		if missing := r.missingOutputs(n.cmd); len(missing) > 0 {
+			return true, "outputs missing: " + strings.Join(missing, ", ")
+		}
+		return false, "already done"
+	case stamped:
+		return true, "signature changed"
+	case r.outputsUpToDate(n.cmd):
+		return false, "outputs are up to date"
+	}
+	return true, "not done"
+}
+
+// run the command of a node, its dependencies must already be done
+func (r *Registry) run(n *node) error {
+	cmd := n.cmd
+	n.sig = r.signature(n)
+	if execute, reason := r.decide(n); !execute {
+		log.Printf("%s %s, skipping...", cmd, reason)
+		return r.markDone(cmd, n.sig)
+	}
+	a, meth, err := r.lookup(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Allocate the services of the command, and deallocate
+	// them when it is done, also if it fails
+	unlock := r.pool.lockFields(cmd, a)
+	defer unlock()
+	allocs, err := r.pool.allocate(cmd, a)
+	if err != nil {
+		return err
+	}
+	defer r.pool.release(a, allocs)
 
 	// Call cmd
-	CallCmd(a[0], cs[1])
+	if err := CallCmd(&a, meth, n.args...); err != nil {
+		return err
+	}
+
+	if missing := r.missingOutputs(cmd); len(missing) > 0 {
+		return fmt.Errorf("outputs not created: %s", strings.Join(missing, ", "))
+	}
 
 	// Mark cmd done
-	markDone(cmd)
+	return r.markDone(cmd, n.sig)
+}
 
+// Flags registers the command line flags setting the options
+// of the registry
+func (r *Registry) Flags(fs *flag.FlagSet) {
+	fs.BoolVar(&r.IgnoreStamps, "ignore-stamps", r.IgnoreStamps, "Ignore stamps and force execution")
+	fs.IntVar(&r.Jobs, "j", r.Jobs, "Number of commands to execute in parallel")
+	fs.DurationVar(&r.ServiceTimeout, "service-timeout", r.ServiceTimeout, "Longest time to wait for services, 0 waits forever")
+	fs.DurationVar(&r.ServiceIdleTimeout, "service-idle", r.ServiceIdleTimeout, "Stop started services unused for this long, 0 stops them at exit")
 }
 
-func init() {
-	dependencies = make(map[string][]string)
-	flag.BoolVar(&IgnoreStamps, "ignore-stamps", false, "Ignore stamps and force execution")
+// Flags registers the command line flags setting the options
+// of the default registry
+func Flags(fs *flag.FlagSet) {
+	Default.Flags(fs)
 }
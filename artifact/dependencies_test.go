@@ -0,0 +1,92 @@
+package artifact
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type Loop struct{ BaseArtifact }
+
+func (l *Loop) Ping() {}
+func (l *Loop) Pong() {}
+
+// below returns the source location n lines below loc
+func below(t *testing.T, loc string, n int) string {
+	i := strings.LastIndex(loc, ":")
+	line, err := strconv.Atoi(loc[i+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%s:%d", loc[:i], line+n)
+}
+
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name      string
+		declare   func(r *Registry) string // returns the location of the first declaration
+		chain     []string
+		locations []int // lines below the first declaration
+	}{
+		{"two commands", func(r *Registry) string {
+			here := caller(0)
+			r.Depends("A.X", "B.Y")
+			r.Depends("B.Y", "A.X")
+			return below(t, here, 1)
+		}, []string{"A.X", "B.Y", "A.X"}, []int{0, 1}},
+		{"a command depending on itself", func(r *Registry) string {
+			here := caller(0)
+			r.Depends("A.X", "B.Y", "A.X")
+			return below(t, here, 1)
+		}, []string{"A.X", "A.X"}, []int{0}},
+		{"method values", func(r *Registry) string {
+			l := new(Loop)
+			r.Add(l)
+			r.Depends("A.X", "Loop.Ping")
+			here := caller(0)
+			r.DependsOn(l.Ping, l.Pong)
+			r.DependsOn(l.Pong, l.Ping)
+			return below(t, here, 1)
+		}, []string{"Loop.Ping", "Loop.Pong", "Loop.Ping"}, []int{0, 1}},
+	}
+	for _, tt := range tests {
+		r := NewRegistry()
+		first := tt.declare(r)
+		err := r.CheckDependencies()
+		ce, ok := err.(*CycleError)
+		if !ok {
+			t.Errorf("%s: CheckDependencies = %v, want a CycleError", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(ce.Chain, tt.chain) {
+			t.Errorf("%s: cycle %v, want %v", tt.name, ce.Chain, tt.chain)
+		}
+		var locs []string
+		for _, n := range tt.locations {
+			locs = append(locs, below(t, first, n))
+		}
+		if !reflect.DeepEqual(ce.Locations, locs) {
+			t.Errorf("%s: declared at %v, want %v", tt.name, ce.Locations, locs)
+		}
+		msg := err.Error()
+		if !strings.HasPrefix(msg, "dependency cycle: "+strings.Join(tt.chain, " -> ")) {
+			t.Errorf("%s: error %q does not show the cycle", tt.name, msg)
+		}
+		for i, loc := range locs {
+			want := fmt.Sprintf("%s -> %s declared at %s", tt.chain[i], tt.chain[i+1], loc)
+			if !strings.Contains(msg, want) {
+				t.Errorf("%s: error %q does not contain %q", tt.name, msg, want)
+			}
+		}
+	}
+
+	// Dependencies without cycles are fine
+	r := NewRegistry()
+	r.Depends("A.X", "B.Y", "C.Z")
+	r.Depends("B.Y", "C.Z")
+	if err := r.CheckDependencies(); err != nil {
+		t.Error(err)
+	}
+}
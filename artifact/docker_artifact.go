@@ -5,6 +5,7 @@ package artifact
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -88,12 +89,18 @@ func addFile(tw *tar.Writer, basepath, path string) error {
 
 func addDir(tw *tar.Writer, basepath, path string) error {
 	log.Printf("Adding directory %q to docker context", path)
-	files, _ := ioutil.ReadDir(filepath.Join(basepath, path))
+	files, err := ioutil.ReadDir(filepath.Join(basepath, path))
+	if err != nil {
+		return err
+	}
 	for _, f := range files {
 		if f.IsDir() {
-			addDir(tw, basepath, filepath.Join(path, f.Name()))
+			err = addDir(tw, basepath, filepath.Join(path, f.Name()))
 		} else {
-			addFile(tw, basepath, filepath.Join(path, f.Name()))
+			err = addFile(tw, basepath, filepath.Join(path, f.Name()))
+		}
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -106,7 +113,7 @@ func createDockerCtxt(path string) (io.Reader, error) {
 	defer tw.Close()
 	err := addDir(tw, path, "")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	return buf, nil
 }
@@ -130,58 +137,68 @@ func getVolume(volDescr string) mount.Mount {
 	}
 }
 
-func fixPath(p string) string {
+func fixPath(p string) (string, error) {
 	// Fix bind mounts on windows with machine driver virtualbox
-	if runtime.GOOS == "windows" && dockermachine.MachineDriver() == "virtualbox" {
+	if runtime.GOOS != "windows" {
+		return "", nil
+	}
+	driver, err := dockermachine.MachineDriver()
+	if err != nil {
+		return "", err
+	}
+	if driver == "virtualbox" {
 		q := filepath.ToSlash(filepath.Clean(p))
 		l := strings.Split(q, ":")
 		switch {
 		case len(l) == 1:
-			return q
+			return q, nil
 		case len(l) == 2:
-			return "//" + strings.ToLower(l[0][0:1]) + l[1]
+			return "//" + strings.ToLower(l[0][0:1]) + l[1], nil
 		case len(l) > 2:
-			log.Fatalf("Invalid path used: %s", p)
+			return "", fmt.Errorf("invalid path used: %s", p)
 		}
 	}
-	return ""
+	return "", nil
 }
 
 // Build a docker image or load it from repository
-func (d *DockerArtifact) Build(args ...string) {
+func (d *DockerArtifact) Build(args ...string) error {
 	if d.isBuilt {
-		return
+		return nil
 	}
 	ctx := context.Background()
 	cli, err := dockermachine.CreateClient()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	buildOptions := types.ImageBuildOptions{
 		Tags:           []string{d.ID()},
 		ForceRemove:    true,
 		SuppressOutput: d.SuppressOutput,
 	}
-	buildCtx, _ := createDockerCtxt(workspace.Resolve(d.ContextFolder))
+	buildCtx, err := createDockerCtxt(workspace.Resolve(d.ContextFolder))
+	if err != nil {
+		return fmt.Errorf("creating docker context: %v", err)
+	}
 	buildImageResponse, err := cli.ImageBuild(ctx, buildCtx, buildOptions)
 	if err != nil {
-		log.Fatalf("ImageBuild error %s", err)
+		return fmt.Errorf("ImageBuild error %s", err)
 	}
 	defer buildImageResponse.Body.Close()
 	// The body contains an output stream of the build result, send it to stdout
 	err = jsonmessage.DisplayJSONMessagesStream(buildImageResponse.Body, os.Stdout, os.Stdout.Fd(), true, nil)
 	if err != nil {
-		log.Fatalf("DisplayJSONMessagesStream error %s", err)
+		return fmt.Errorf("DisplayJSONMessagesStream error %s", err)
 	}
 	d.isBuilt = true
+	return nil
 }
 
 func getCid(c *client.Client, name string) (string, error) {
 	ctx := context.Background()
 	containers, err := c.ContainerList(ctx, types.ContainerListOptions{All: true})
 	if err != nil {
-		log.Fatalf("ContainerList error %s", err)
-		return "", err
+		return "", fmt.Errorf("ContainerList error %s", err)
 	}
 	for _, cont := range containers {
 		for _, n := range cont.Names {
@@ -196,31 +213,36 @@ func getCid(c *client.Client, name string) (string, error) {
 func removeContainer(c *client.Client, name string) error {
 	ctx := context.Background()
 	cid, err := getCid(c, name)
+	if err != nil {
+		return err
+	}
 	if cid != "" {
 		// Remove the container that has our name
 		timeout := 500 * time.Millisecond
 		if err = c.ContainerStop(ctx, cid, &timeout); err != nil {
-			log.Fatalf("ContainerStop returns %s", err)
+			return fmt.Errorf("ContainerStop returns %s", err)
 		}
 
 		if err = c.ContainerRemove(ctx, cid, types.ContainerRemoveOptions{Force: true}); err != nil {
-			log.Fatalf("ContainerRemove returns %s", err)
+			return fmt.Errorf("ContainerRemove returns %s", err)
 		}
 	}
 	return nil
 }
 
 // Run executes the docker container that was created in the Build method
-func (d *DockerArtifact) Run(args ...string) {
-	d.Build()
+func (d *DockerArtifact) Run(args ...string) error {
+	if err := d.Build(); err != nil {
+		return err
+	}
 	ctx := context.Background()
 	cli, err := dockermachine.CreateClient()
 	if err != nil {
-		log.Fatalf("CreateClient error %s", err)
+		return fmt.Errorf("CreateClient error %s", err)
 	}
 	// Make sure container does not alread exist
 	if err := removeContainer(cli, d.ID()); err != nil {
-		log.Fatalf("removeContainer error %s", err)
+		return fmt.Errorf("removeContainer error %s", err)
 	}
 
 	config := container.Config{
@@ -238,7 +260,11 @@ func (d *DockerArtifact) Run(args ...string) {
 
 	// Bind mounts
 	for k, v := range d.Bindings {
-		hostConfig.Binds = append(hostConfig.Binds, fixPath(workspace.Resolve(k))+":"+v)
+		p, err := fixPath(workspace.Resolve(k))
+		if err != nil {
+			return err
+		}
+		hostConfig.Binds = append(hostConfig.Binds, p+":"+v)
 	}
 
 	// Set volume mounts
@@ -253,36 +279,43 @@ func (d *DockerArtifact) Run(args ...string) {
 	// Create container
 	buildContainerResponse, err := cli.ContainerCreate(ctx, &config, &hostConfig, &networkConfig, d.ID())
 	if err != nil {
-		log.Fatalf("ContainerCreate error %s", err)
+		return fmt.Errorf("ContainerCreate error %s", err)
 	}
 	d.ContainerID = buildContainerResponse.ID
 	log.Printf("Docker Container created %s: %s", d.ID(), d.ContainerID)
 
+	// Always try to remove the container, also when running it fails
+	defer func() {
+		timeout := 500 * time.Millisecond
+		if err := cli.ContainerStop(ctx, d.ContainerID, &timeout); err != nil {
+			log.Printf("ContainerStop returns %s", err)
+		}
+		if err := cli.ContainerRemove(ctx, d.ContainerID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			log.Printf("ContainerRemove returns %s", err)
+		}
+	}()
+
 	if err := cli.ContainerStart(ctx, d.ContainerID, types.ContainerStartOptions{}); err != nil {
-		log.Fatalf("ContainerStart error %s", err)
+		return fmt.Errorf("ContainerStart error %s", err)
 	}
 
 	out, err := cli.ContainerLogs(ctx, d.ContainerID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		return fmt.Errorf("ContainerLogs error %s", err)
+	}
 	defer out.Close()
 
 	_, err = io.Copy(os.Stdout, out)
 	if err != nil {
-		log.Fatalf("io.Copy error %s", err)
-	}
-
-	if _, err = cli.ContainerWait(ctx, d.ContainerID); err != nil {
-		log.Fatalf("ContainerWait error %s", err)
+		return fmt.Errorf("io.Copy error %s", err)
 	}
 
+	exitCode, err := cli.ContainerWait(ctx, d.ContainerID)
 	if err != nil {
-		log.Fatal(err.Error())
-	}
-	timeout := 500 * time.Millisecond
-	if err = cli.ContainerStop(ctx, d.ContainerID, &timeout); err != nil {
-		log.Fatalf("ContainerStop returns %s", err)
+		return fmt.Errorf("ContainerWait error %s", err)
 	}
-
-	if err = cli.ContainerRemove(ctx, d.ContainerID, types.ContainerRemoveOptions{Force: true}); err != nil {
-		log.Fatalf("ContainerRemove returns %s", err)
+	if exitCode != 0 {
+		return fmt.Errorf("%s exited with status %d", strings.Join(args, " "), exitCode)
 	}
+	return nil
 }
@@ -0,0 +1,61 @@
+package artifact
+
+import (
+	"fmt"
+)
+
+// A Step is a command in an execution plan, as returned by Plan
+type Step struct {
+	Cmd      string
+	Args     []string
+	Depends  []string
+	Run      bool   // true if the command would be executed
+	Reason   string // why the command would be executed or skipped
+	Services []ServiceBinding
+	Missing  []*InjectionError // required services the command would fail without
+}
+
+// Plan resolves what CallWithArgs would do, without executing anything.
+// The steps are returned in an order they could be executed in.
+func (r *Registry) Plan(args []string, cmds ...string) ([]Step, error) {
+	if err := r.CheckDependencies(); err != nil {
+		return nil, err
+	}
+	p := r.newPlan(args, cmds...)
+	willRun := make(map[*node]bool)
+	var res []Step
+	for _, n := range p.order {
+		n.sig = r.signature(n)
+		st := Step{Cmd: n.cmd, Args: n.args}
+		for _, d := range n.deps {
+			st.Depends = append(st.Depends, d.cmd)
+		}
+		st.Run, st.Reason = r.decide(n)
+		if !st.Run {
+			// A dependency being executed makes the signature change
+			for _, d := range n.deps {
+				if willRun[d] {
+					st.Run, st.Reason = true, fmt.Sprintf("dependency %s will run", d.cmd)
+					break
+				}
+			}
+		}
+		if st.Run {
+			a, _, err := r.lookup(n.cmd)
+			if err == nil {
+				st.Services, st.Missing, err = r.bindServices(n.cmd, a)
+			}
+			if err != nil {
+				st.Reason = fmt.Sprintf("would fail: %v", err)
+			}
+		}
+		willRun[n] = st.Run
+		res = append(res, st)
+	}
+	return res, nil
+}
+
+// Plan resolves what CallWithArgs would do with the default registry
+func Plan(args []string, cmds ...string) ([]Step, error) {
+	return Default.Plan(args, cmds...)
+}
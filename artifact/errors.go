@@ -0,0 +1,108 @@
+package artifact
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A CommandError is returned when a command fails
+type CommandError struct {
+	Cmd string
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Cmd, e.Err)
+}
+
+// A DependencyError is returned for a command that was not
+// executed since one of its dependencies failed
+type DependencyError struct {
+	Cmd        string
+	Dependency string
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("%s not executed, dependency %s failed", e.Cmd, e.Dependency)
+}
+
+// A BuildError summarizes all commands that failed, or were not
+// executed, during a call
+type BuildError struct {
+	Errors []error
+}
+
+func (e *BuildError) Error() string {
+	var failed, notExecuted int
+	for _, err := range e.Errors {
+		if _, ok := err.(*DependencyError); ok {
+			notExecuted++
+		} else {
+			failed++
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "build failed, %d command(s) failed and %d not executed:", failed, notExecuted)
+	for _, err := range e.Errors {
+		fmt.Fprintf(&b, "\n\t%v", err)
+	}
+	return b.String()
+}
+
+// A VersionError is returned when no artifact with the name
+// satisfies the version constraint
+type VersionError struct {
+	Name       string
+	Constraint string
+	Pinned     bool // the constraint is pinned in the workspace config file
+	Available  []Version
+}
+
+func (e *VersionError) Error() string {
+	var vs []string
+	for _, v := range e.Available {
+		vs = append(vs, v.String())
+	}
+	pinned := ""
+	if e.Pinned {
+		pinned = " (pinned in the workspace config file)"
+	}
+	return fmt.Sprintf("no version of %q satisfies %q%s, available versions: %s",
+		e.Name, e.Constraint, pinned, strings.Join(vs, ", "))
+}
+
+// A Rejection tells why a registered service was not
+// injected into a field
+type Rejection struct {
+	Service string // the ServiceID
+	Reason  string
+}
+
+// An InjectionError is returned when no registered service can be
+// injected into a requirement tagged field of an artifact
+type InjectionError struct {
+	Artifact    string
+	Field       string
+	Type        string // the service interface of the field
+	Requirement string
+	Service     string // the requested service name, or ""
+	Rejected    []Rejection
+}
+
+func (e *InjectionError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "no service to inject into %s.%s (%s)", e.Artifact, e.Field, e.Type)
+	if e.Service != "" {
+		fmt.Fprintf(&b, " named %q", e.Service)
+	}
+	if e.Requirement != "" || e.Service == "" {
+		fmt.Fprintf(&b, " with requirement %q", e.Requirement)
+	}
+	if len(e.Rejected) == 0 {
+		b.WriteString(", no services are registered")
+	}
+	for _, r := range e.Rejected {
+		fmt.Fprintf(&b, "\n\t\t%s: %s", r.Service, r.Reason)
+	}
+	return b.String()
+}
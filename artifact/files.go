@@ -0,0 +1,197 @@
+package artifact
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// A fileDecl is a file, or glob of files, declared as input
+// or output of a command
+type fileDecl struct {
+	path string
+	loc  string // source location of the declaration
+}
+
+// Inputs declares files read by cmd. The paths are resolved through
+// workspace.Resolve and may be globs. Directories include all files
+// below them. If another command declares an output matching one of
+// the inputs, cmd will depend on that command.
+// Inputs("AMBuilder.Configure", "${WORKSPACE}/configure.ac", "${WORKSPACE}/*.am")
+func (r *Registry) Inputs(cmd string, files ...string) {
+	r.addFiles(r.inputFiles, cmd, caller(1), files...)
+}
+
+// Outputs declares files written by cmd. The paths are resolved through
+// workspace.Resolve. If all outputs are newer than the inputs when no
+// stamp is recorded, the command is skipped. After executing cmd, all
+// outputs must exist.
+// Outputs("AMBuilder.Install", "${WORKSPACE}/hello_crazy_build-1.0.tar.gz")
+func (r *Registry) Outputs(cmd string, files ...string) {
+	r.addFiles(r.outputFiles, cmd, caller(1), files...)
+}
+
+// Inputs declares files read by cmd in the default registry
+func Inputs(cmd string, files ...string) {
+	Default.addFiles(Default.inputFiles, cmd, caller(1), files...)
+}
+
+// Outputs declares files written by cmd in the default registry
+func Outputs(cmd string, files ...string) {
+	Default.addFiles(Default.outputFiles, cmd, caller(1), files...)
+}
+
+func (r *Registry) addFiles(decls map[string][]fileDecl, cmd string, loc string, files ...string) {
+	for _, f := range files {
+		decls[cmd] = append(decls[cmd], fileDecl{path: f, loc: loc})
+	}
+}
+
+// GetInputs returns the input declarations of cmd, as declared
+func (r *Registry) GetInputs(cmd string) []string {
+	return declaredPaths(r.declarations(r.inputFiles, r.canonical(cmd)))
+}
+
+// GetOutputs returns the output declarations of cmd, as declared
+func (r *Registry) GetOutputs(cmd string) []string {
+	return declaredPaths(r.declarations(r.outputFiles, r.canonical(cmd)))
+}
+
+// GetInputs returns the input declarations of cmd in the default registry
+func GetInputs(cmd string) []string {
+	return Default.GetInputs(cmd)
+}
+
+// GetOutputs returns the output declarations of cmd in the default registry
+func GetOutputs(cmd string) []string {
+	return Default.GetOutputs(cmd)
+}
+
+// declarations returns the file declarations of all names
+// resolving to the canonical command cmd
+func (r *Registry) declarations(decls map[string][]fileDecl, cmd string) []fileDecl {
+	var declared []string
+	for d := range decls {
+		declared = append(declared, d)
+	}
+	sort.Strings(declared)
+	var res []fileDecl
+	for _, d := range r.sameCommand(cmd, declared) {
+		res = append(res, decls[d]...)
+	}
+	return res
+}
+
+func declaredPaths(decls []fileDecl) []string {
+	var res []string
+	for _, d := range decls {
+		res = append(res, d.path)
+	}
+	return res
+}
+
+// fileEdges returns the dependencies implied by cmd reading
+// files that other commands write
+func (r *Registry) fileEdges(cmd string) []dependency {
+	var producers []string
+	for p := range r.outputFiles {
+		producers = append(producers, p)
+	}
+	sort.Strings(producers)
+
+	var res []dependency
+	for _, in := range r.declarations(r.inputFiles, cmd) {
+		pattern := filepath.Clean(workspace.Resolve(in.path))
+		for _, p := range producers {
+			producer := r.canonical(p)
+			if producer == cmd {
+				continue
+			}
+			for _, out := range r.outputFiles[p] {
+				if matchesInput(pattern, filepath.Clean(workspace.Resolve(out.path))) {
+					res = append(res, dependency{cmd: producer, loc: in.loc, implied: true})
+					break
+				}
+			}
+		}
+	}
+	return res
+}
+
+// matchesInput tells if the output path is covered by the input pattern
+func matchesInput(pattern, path string) bool {
+	if pattern == path || strings.HasPrefix(path, pattern+string(filepath.Separator)) {
+		return true
+	}
+	m, _ := filepath.Match(pattern, path)
+	return m
+}
+
+// expand resolves the declarations into paths, expanding globs.
+// Paths that do not exist are kept so they can be reported.
+func expand(decls []fileDecl) []string {
+	var res []string
+	for _, d := range decls {
+		p := workspace.Resolve(d.path)
+		matches, err := filepath.Glob(p)
+		if err != nil || len(matches) == 0 {
+			res = append(res, p)
+			continue
+		}
+		res = append(res, matches...)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// missingOutputs returns the declared outputs of cmd that do not exist
+func (r *Registry) missingOutputs(cmd string) []string {
+	var res []string
+	for _, o := range expand(r.declarations(r.outputFiles, cmd)) {
+		if _, err := os.Stat(o); err != nil {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+// outputsUpToDate tells if cmd declares outputs and all of them
+// are newer than every input
+func (r *Registry) outputsUpToDate(cmd string) bool {
+	outputs := r.declarations(r.outputFiles, cmd)
+	if len(outputs) == 0 {
+		return false
+	}
+	var oldest time.Time
+	for _, o := range expand(outputs) {
+		info, err := os.Stat(o)
+		if err != nil {
+			return false
+		}
+		if oldest.IsZero() || info.ModTime().Before(oldest) {
+			oldest = info.ModTime()
+		}
+	}
+	for _, in := range expand(r.declarations(r.inputFiles, cmd)) {
+		err := filepath.Walk(in, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(oldest) {
+				return errOutdated
+			}
+			return nil
+		})
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+var errOutdated = errors.New("outdated")
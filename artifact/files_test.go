@@ -0,0 +1,150 @@
+package artifact
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileEdges(t *testing.T) {
+	dir := testWorkspace(t)
+	r := NewRegistry()
+	r.Outputs("Gen.Header", filepath.Join(dir, "gen", "config.h"))
+	r.Outputs("Gen.Table", filepath.Join(dir, "table.c"))
+	r.Outputs("Self.Copy", filepath.Join(dir, "copy.txt"))
+	r.Inputs("Self.Copy", filepath.Join(dir, "copy.txt"))
+	r.Inputs("Use.Dir", filepath.Join(dir, "gen"))
+	r.Inputs("Use.Glob", filepath.Join(dir, "*.c"))
+	r.Inputs("Use.Other", filepath.Join(dir, "other.c"), filepath.Join(dir, "gen", "other.h"))
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		// A directory input covers the outputs below it
+		{"Use.Dir", []string{"Gen.Header"}},
+		{"Use.Glob", []string{"Gen.Table"}},
+		{"Use.Other", nil},
+		// Writing its own input is not a dependency
+		{"Self.Copy", nil},
+	}
+	for _, tt := range tests {
+		if got := r.Dependencies(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s depends on %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestOutputsUpToDate(t *testing.T) {
+	dir := testWorkspace(t)
+	now := time.Now()
+	file := func(name string, age time.Duration) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	old := file("old.txt", 2*time.Hour)
+	out := file("out.txt", time.Hour)
+	recent := file("recent.txt", time.Minute)
+	src := filepath.Join(dir, "src")
+	file("src/old.c", 2*time.Hour)
+	if err := os.Chtimes(src, now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		inputs  []string
+		outputs []string
+		want    bool
+	}{
+		{"inputs older", []string{old}, []string{out}, true},
+		{"an input newer", []string{old, recent}, []string{out}, false},
+		{"an output missing", []string{old}, []string{out, filepath.Join(dir, "missing.txt")}, false},
+		{"no outputs", []string{old}, nil, false},
+		{"no inputs", nil, []string{out}, true},
+		{"directory inputs older", []string{src}, []string{out}, true},
+	}
+	for _, tt := range tests {
+		r := NewRegistry()
+		r.Inputs("Maker.Make", tt.inputs...)
+		r.Outputs("Maker.Make", tt.outputs...)
+		if got := r.outputsUpToDate("Maker.Make"); got != tt.want {
+			t.Errorf("%s: outputsUpToDate = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A file in an input directory newer than the outputs
+	file("src/new.c", time.Minute)
+	r := NewRegistry()
+	r.Inputs("Maker.Make", src)
+	r.Outputs("Maker.Make", out)
+	if r.outputsUpToDate("Maker.Make") {
+		t.Error("outputs are up to date, while src/new.c is newer")
+	}
+}
+
+// A Maker writes its output when write is set
+type Maker struct {
+	BaseArtifact
+	out   string
+	write bool
+	runs  int
+}
+
+func (m *Maker) Make() error {
+	m.runs++
+	if !m.write {
+		return nil
+	}
+	return os.WriteFile(m.out, []byte("made"), 0666)
+}
+
+func TestMissingOutputs(t *testing.T) {
+	dir := testWorkspace(t)
+	in := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(in, []byte("in"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	m := &Maker{out: filepath.Join(dir, "out.txt"), write: true}
+	r := NewRegistry()
+	r.Add(m)
+	r.Inputs("Maker.Make", in)
+	r.Outputs("Maker.Make", m.out)
+
+	tests := []struct {
+		name   string
+		change func()
+		runs   int
+		err    string
+	}{
+		{"first call", func() {}, 1, ""},
+		{"nothing changed", func() {}, 0, ""},
+		{"output removed", func() { os.Remove(m.out) }, 1, ""},
+		{"output not created", func() { os.Remove(m.out); m.write = false }, 1, "outputs not created: " + m.out},
+	}
+	for _, tt := range tests {
+		tt.change()
+		m.runs = 0
+		err := r.Call("Maker.Make")
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: Call = %v, want %q", tt.name, err, tt.err)
+		}
+		if m.runs != tt.runs {
+			t.Errorf("%s: Maker.Make executed %d times, want %d", tt.name, m.runs, tt.runs)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package artifact
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Kinds of graph nodes
+const (
+	CommandNode = "command"
+	ServiceNode = "service"
+)
+
+// Kinds of graph edges
+const (
+	DependsEdge = "depends" // declared with Depends
+	FileEdge    = "file"    // implied by input and output files
+	ServiceEdge = "service" // service injected for the command
+)
+
+// States of command nodes
+const (
+	Done    = "done"    // stamped with the current signature
+	Pending = "pending" // would be executed
+)
+
+// A GraphNode is a command or a service in the graph
+type GraphNode struct {
+	ID      string   `json:"id"`
+	Kind    string   `json:"kind"`
+	State   string   `json:"state,omitempty"`
+	Inputs  []string `json:"inputs,omitempty"`
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+// A GraphEdge goes from a command to what it depends on
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Kind     string `json:"kind"`
+	Field    string `json:"field,omitempty"`    // the injected field of service edges
+	Shared   bool   `json:"shared,omitempty"`   // the service is shared by the commands of the artifact
+	Location string `json:"location,omitempty"` // where the edge was declared
+}
+
+// label returns the label of a service edge
+func (e GraphEdge) label() string {
+	if e.Shared {
+		return e.Field + ", shared"
+	}
+	return e.Field
+}
+
+// A Graph of commands, their dependencies and the services
+// injected for them
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GetGraph returns the graph of the commands and everything they
+// depend on. Without commands, the graph holds the commands of
+// all artifacts.
+func (r *Registry) GetGraph(cmds ...string) (*Graph, error) {
+	if len(cmds) == 0 {
+		for _, a := range r.All() {
+			for _, c := range GetCommands(a) {
+				cmds = append(cmds, r.ID(a)+"."+c)
+			}
+		}
+	}
+	steps, err := r.Plan(nil, cmds...)
+	if err != nil {
+		return nil, err
+	}
+	g := new(Graph)
+	seen := make(map[string]bool)
+	for _, st := range steps {
+		state := Done
+		if st.Run {
+			state = Pending
+		}
+		g.Nodes = append(g.Nodes, GraphNode{
+			ID:      st.Cmd,
+			Kind:    CommandNode,
+			State:   state,
+			Inputs:  r.GetInputs(st.Cmd),
+			Outputs: r.GetOutputs(st.Cmd),
+		})
+		for _, d := range r.edges(st.Cmd) {
+			kind := DependsEdge
+			if d.implied {
+				kind = FileEdge
+			}
+			g.Edges = append(g.Edges, GraphEdge{From: st.Cmd, To: d.cmd, Kind: kind, Location: d.loc})
+		}
+		// Also skipped commands show which services they would get
+		a, _, err := r.lookup(st.Cmd)
+		if err != nil {
+			continue
+		}
+		bindings, _, _ := r.bindServices(st.Cmd, a)
+		for _, b := range bindings {
+			id := r.ServiceID(b.Service)
+			if !seen[id] {
+				seen[id] = true
+				g.Nodes = append(g.Nodes, GraphNode{ID: id, Kind: ServiceNode})
+			}
+			g.Edges = append(g.Edges, GraphEdge{From: st.Cmd, To: id, Kind: ServiceEdge, Field: b.Field, Shared: b.Shared})
+		}
+	}
+	return g, nil
+}
+
+// GetGraph returns the graph of the commands of the default registry
+func GetGraph(cmds ...string) (*Graph, error) {
+	return Default.GetGraph(cmds...)
+}
+
+// JSON returns the graph in JSON format
+func (g *Graph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+// DOT returns the graph in the Graphviz DOT format. Commands are colored
+// by their stamp state, and services are drawn as boxes.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph commands {\n")
+	fmt.Fprintf(&b, "    rankdir=LR;\n")
+	fmt.Fprintf(&b, "    node [style=filled];\n")
+	for _, n := range g.Nodes {
+		switch {
+		case n.Kind == ServiceNode:
+			fmt.Fprintf(&b, "    %q [shape=box, fillcolor=lightblue];\n", n.ID)
+		case n.State == Done:
+			fmt.Fprintf(&b, "    %q [shape=ellipse, fillcolor=palegreen];\n", n.ID)
+		default:
+			fmt.Fprintf(&b, "    %q [shape=ellipse, fillcolor=lightsalmon];\n", n.ID)
+		}
+	}
+	for _, e := range g.Edges {
+		switch e.Kind {
+		case FileEdge:
+			fmt.Fprintf(&b, "    %q -> %q [style=dashed];\n", e.From, e.To)
+		case ServiceEdge:
+			fmt.Fprintf(&b, "    %q -> %q [style=dotted, label=%q];\n", e.From, e.To, e.label())
+		default:
+			fmt.Fprintf(&b, "    %q -> %q;\n", e.From, e.To)
+		}
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+// Mermaid returns the graph as a Mermaid flowchart, with the same
+// coloring as DOT
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+	ids := make(map[string]string)
+	fmt.Fprintf(&b, "flowchart LR\n")
+	fmt.Fprintf(&b, "    classDef done fill:#98fb98\n")
+	fmt.Fprintf(&b, "    classDef pending fill:#ffa07a\n")
+	fmt.Fprintf(&b, "    classDef service fill:#add8e6\n")
+	for i, n := range g.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[n.ID] = id
+		label := strings.Replace(n.ID, "\"", "#quot;", -1)
+		switch {
+		case n.Kind == ServiceNode:
+			fmt.Fprintf(&b, "    %s[\"%s\"]:::service\n", id, label)
+		case n.State == Done:
+			fmt.Fprintf(&b, "    %s([\"%s\"]):::done\n", id, label)
+		default:
+			fmt.Fprintf(&b, "    %s([\"%s\"]):::pending\n", id, label)
+		}
+	}
+	for _, e := range g.Edges {
+		to, ok := ids[e.To]
+		if !ok {
+			continue
+		}
+		switch e.Kind {
+		case FileEdge:
+			fmt.Fprintf(&b, "    %s -.-> %s\n", ids[e.From], to)
+		case ServiceEdge:
+			fmt.Fprintf(&b, "    %s -. %s .-> %s\n", ids[e.From], e.label(), to)
+		default:
+			fmt.Fprintf(&b, "    %s --> %s\n", ids[e.From], to)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package artifact
+
+import (
+	"testing"
+)
+
+// A Pipe is a diamond of commands, with a version in its ID
+type Pipe struct {
+	BaseArtifact
+	Machine Machine `requirement:"os=linux" command:"Link" inject:"shared"`
+}
+
+func (p *Pipe) Version() Version { return MustParseVersion("1.0.0-rc.1") }
+func (p *Pipe) Fetch()           {}
+func (p *Pipe) Left()            {}
+func (p *Pipe) Right()           {}
+func (p *Pipe) Link()            {}
+
+// A quotedMachine has a name that needs escaping
+type quotedMachine struct {
+	propMachine
+}
+
+func (m *quotedMachine) ServiceName() string { return `docker "big"` }
+
+func diamond(t *testing.T) *Graph {
+	r := unstampedRegistry()
+	r.Add(new(Pipe))
+	r.RegisterServiceInstance(&quotedMachine{propMachine{plainMachine{"big"}, Properties{"os": "linux"}, 0}})
+	r.Depends("pipe.Left", "pipe.Fetch")
+	r.Depends("pipe.Right", "pipe.Fetch")
+	r.Depends("pipe.Link", "pipe.Left")
+	r.Outputs("pipe.Right", "right.o")
+	r.Inputs("pipe.Link", "right.o")
+	g, err := r.GetGraph("pipe.Link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGraphDOT(t *testing.T) {
+	want := `digraph commands {
+    rankdir=LR;
+    node [style=filled];
+    "Pipe@=1.0.0-rc.1.Fetch" [shape=ellipse, fillcolor=lightsalmon];
+    "Pipe@=1.0.0-rc.1.Left" [shape=ellipse, fillcolor=lightsalmon];
+    "Pipe@=1.0.0-rc.1.Right" [shape=ellipse, fillcolor=lightsalmon];
+    "Pipe@=1.0.0-rc.1.Link" [shape=ellipse, fillcolor=lightsalmon];
+    "docker \"big\"" [shape=box, fillcolor=lightblue];
+    "Pipe@=1.0.0-rc.1.Left" -> "Pipe@=1.0.0-rc.1.Fetch";
+    "Pipe@=1.0.0-rc.1.Right" -> "Pipe@=1.0.0-rc.1.Fetch";
+    "Pipe@=1.0.0-rc.1.Link" -> "Pipe@=1.0.0-rc.1.Left";
+    "Pipe@=1.0.0-rc.1.Link" -> "Pipe@=1.0.0-rc.1.Right" [style=dashed];
+    "Pipe@=1.0.0-rc.1.Link" -> "docker \"big\"" [style=dotted, label="Machine, shared"];
+}
+`
+	if got := diamond(t).DOT(); got != want {
+		t.Errorf("DOT =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGraphMermaid(t *testing.T) {
+	want := `flowchart LR
+    classDef done fill:#98fb98
+    classDef pending fill:#ffa07a
+    classDef service fill:#add8e6
+    n0(["Pipe@=1.0.0-rc.1.Fetch"]):::pending
+    n1(["Pipe@=1.0.0-rc.1.Left"]):::pending
+    n2(["Pipe@=1.0.0-rc.1.Right"]):::pending
+    n3(["Pipe@=1.0.0-rc.1.Link"]):::pending
+    n4["docker #quot;big#quot;"]:::service
+    n1 --> n0
+    n2 --> n0
+    n3 --> n1
+    n3 -.-> n2
+    n3 -. Machine, shared .-> n4
+`
+	if got := diamond(t).Mermaid(); got != want {
+		t.Errorf("Mermaid =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A LifecycleService needs starting before it is used, like a virtual
+// machine. It is started when the first command needing it gets it
+// injected, and stopped when it has not been allocated for the
+// ServiceIdleTimeout of the registry or when the build binary exits.
+type LifecycleService interface {
+	// Start the service
+	Start(ctx context.Context) error
+
+	// Healthy returns nil when the started service can be used.
+	// It is polled until it does, or the start times out.
+	Healthy(ctx context.Context) error
+
+	// Stop the service
+	Stop(ctx context.Context) error
+}
+
+// serviceStopTimeout is the longest time a service may take to stop
+const serviceStopTimeout = time.Minute
+
+// The states of a LifecycleService
+const (
+	stateStopped  = "stopped"
+	stateStarting = "starting"
+	stateRunning  = "running"
+	stateStopping = "stopping"
+)
+
+// lifecycle is the state of a LifecycleService in the pool
+type lifecycle struct {
+	mu      sync.Mutex // held while the service starts or stops
+	running bool
+
+	state string      // guarded by the mutex of the pool
+	idle  *time.Timer // stops the service when it is idle, guarded by the mutex of the pool
+}
+
+// start starts the services that are not running. If a service
+// fails to start or to become healthy, the error is returned.
+func (p *ServicePool) start(allocs []*Allocation) error {
+	for _, al := range allocs {
+		s := al.owner
+		if s.life == nil {
+			continue
+		}
+		if err := p.startService(s, al.Service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ServicePool) startService(s *pooled, id string) error {
+	s.life.mu.Lock()
+	defer s.life.mu.Unlock()
+	if s.life.running {
+		return nil
+	}
+	ls := s.service.(LifecycleService)
+	log.Printf("Starting service %s", id)
+	p.setState(s, stateStarting)
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.ServiceStartTimeout)
+	defer cancel()
+	err := ls.Start(ctx)
+	if err == nil {
+		err = waitHealthy(ctx, ls)
+	}
+	if err != nil {
+		// Clean up what may have been started
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), serviceStopTimeout)
+		defer stopCancel()
+		if stopErr := ls.Stop(stopCtx); stopErr != nil {
+			log.Printf("Failed to stop service %s: %v", id, stopErr)
+		}
+		p.setState(s, stateStopped)
+		return fmt.Errorf("failed to start service %s: %v", id, err)
+	}
+	s.life.running = true
+	p.setState(s, stateRunning)
+	return nil
+}
+
+// waitHealthy polls the health of the service, waiting twice as
+// long after each failed check, at most 5 seconds
+func waitHealthy(ctx context.Context, ls LifecycleService) error {
+	delay := backoff(0)
+	for {
+		err := ls.Healthy(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not healthy: %v", err)
+		case <-time.After(delay):
+		}
+		delay = backoff(delay)
+	}
+}
+
+// stopService stops the service if it is running. If idle is set, it
+// is only stopped if it is still not allocated.
+func (p *ServicePool) stopService(s *pooled, idle bool) error {
+	s.life.mu.Lock()
+	defer s.life.mu.Unlock()
+	if !s.life.running {
+		return nil
+	}
+	p.mu.Lock()
+	id := p.id(s.service)
+	busy := len(s.allocs) > 0
+	p.mu.Unlock()
+	if idle && busy {
+		return nil
+	}
+	if idle {
+		log.Printf("Stopping service %s, idle for %v", id, p.opts.ServiceIdleTimeout)
+	} else {
+		log.Printf("Stopping service %s", id)
+	}
+	p.setState(s, stateStopping)
+	ctx, cancel := context.WithTimeout(context.Background(), serviceStopTimeout)
+	defer cancel()
+	err := s.service.(LifecycleService).Stop(ctx)
+	s.life.running = false
+	p.setState(s, stateStopped)
+	if err != nil {
+		return fmt.Errorf("failed to stop service %s: %v", id, err)
+	}
+	return nil
+}
+
+// idleFrom schedules stopping the service when it is no longer
+// allocated. The caller must hold mu.
+func (p *ServicePool) idleFrom(s *pooled) {
+	if s.life == nil || p.opts.ServiceIdleTimeout <= 0 {
+		return
+	}
+	if s.life.idle != nil {
+		s.life.idle.Stop()
+		s.life.idle = nil
+	}
+	if len(s.allocs) > 0 {
+		return
+	}
+	s.life.idle = time.AfterFunc(p.opts.ServiceIdleTimeout, func() {
+		if err := p.stopService(s, true); err != nil {
+			log.Print(err)
+		}
+	})
+}
+
+func (p *ServicePool) setState(s *pooled, state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.life.state = state
+	p.publish()
+}
+
+// Stop stops all started services, when the build is done
+func (p *ServicePool) Stop() error {
+	p.mu.Lock()
+	services := append([]*pooled(nil), p.services...)
+	for _, s := range services {
+		if s.life != nil && s.life.idle != nil {
+			s.life.idle.Stop()
+			s.life.idle = nil
+		}
+	}
+	p.mu.Unlock()
+	var errs []string
+	for _, s := range services {
+		if s.life == nil {
+			continue
+		}
+		if err := p.stopService(s, false); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
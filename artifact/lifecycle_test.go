@@ -0,0 +1,169 @@
+package artifact
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A bootMachine needs starting, and is healthy after the
+// given number of health checks, or never if it is 0
+type bootMachine struct {
+	*capMachine
+	healthyAfter int
+
+	mu     sync.Mutex
+	starts int
+	checks int
+	stops  int
+}
+
+func (m *bootMachine) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.starts++
+	m.checks = 0
+	return nil
+}
+
+func (m *bootMachine) Healthy(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checks++
+	if m.healthyAfter == 0 || m.checks < m.healthyAfter {
+		return errors.New("still booting")
+	}
+	return nil
+}
+
+func (m *bootMachine) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stops++
+	return nil
+}
+
+// counts returns the number of starts, health checks and stops
+func (m *bootMachine) counts() (int, int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.starts, m.checks, m.stops
+}
+
+func state(p *ServicePool) string {
+	return p.Status().Services[0].State
+}
+
+func TestLifecycleStart(t *testing.T) {
+	r := NewRegistry()
+	m := &bootMachine{capMachine: newCapMachine("vm", 0, 0), healthyAfter: 3}
+	r.RegisterServiceInstance(m)
+	p := r.Services()
+	if starts, _, _ := m.counts(); starts != 0 || state(p) != stateStopped {
+		t.Fatalf("%d starts and state %s before the first allocation, want none and stopped", starts, state(p))
+	}
+
+	// The first allocation starts the service, and waits for it to be healthy
+	first := new(Stage)
+	allocs, err := p.allocate("Stage.Run", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if starts, checks, _ := m.counts(); starts != 1 || checks != 3 || state(p) != stateRunning {
+		t.Errorf("%d starts, %d health checks and state %s, want 1, 3 and running", starts, checks, state(p))
+	}
+
+	// The running service is not started again
+	second := new(Stage)
+	again, err := p.allocate("Stage.Run", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if starts, _, _ := m.counts(); starts != 1 {
+		t.Errorf("%d starts, want the running service not started again", starts)
+	}
+	p.release(first, allocs)
+	p.release(second, again)
+
+	// Without an idle timeout it runs until stopped
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, stops := m.counts(); stops != 1 || state(p) != stateStopped {
+		t.Errorf("%d stops and state %s after Stop, want 1 and stopped", stops, state(p))
+	}
+}
+
+func TestLifecycleStartTimeout(t *testing.T) {
+	r := NewRegistry()
+	r.ServiceStartTimeout = 500 * time.Millisecond
+	m := &bootMachine{capMachine: newCapMachine("vm", 0, 0)}
+	r.RegisterServiceInstance(m)
+	p := r.Services()
+
+	start := time.Now()
+	s := new(Stage)
+	_, err := p.allocate("Stage.Run", s)
+	if err == nil || !strings.Contains(err.Error(), "failed to start service") ||
+		!strings.Contains(err.Error(), "not healthy: still booting") {
+		t.Fatalf("allocate = %v, want the service not to become healthy", err)
+	}
+	if d := time.Since(start); d < r.ServiceStartTimeout {
+		t.Errorf("allocate failed after %v, before the start timeout", d)
+	}
+	// Checked after 0, 100 and 300 milliseconds, the next check would be at 700
+	starts, checks, stops := m.counts()
+	if starts != 1 || checks != 3 || stops != 1 {
+		t.Errorf("%d starts, %d health checks and %d stops, want 1, 3 and 1", starts, checks, stops)
+	}
+	if state(p) != stateStopped || s.Machine != nil || m.allocated != 0 {
+		t.Errorf("state %s, %v injected and %d allocations, want the failed service stopped and deallocated",
+			state(p), s.Machine, m.allocated)
+	}
+}
+
+func TestLifecycleIdle(t *testing.T) {
+	r := NewRegistry()
+	r.ServiceIdleTimeout = 100 * time.Millisecond
+	m := &bootMachine{capMachine: newCapMachine("vm", 0, 0), healthyAfter: 1}
+	r.RegisterServiceInstance(m)
+	p := r.Services()
+	defer p.Stop()
+
+	s := new(Stage)
+	allocs, err := p.allocate("Stage.Run", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An allocated service is not idle
+	time.Sleep(2 * r.ServiceIdleTimeout)
+	if _, _, stops := m.counts(); stops != 0 {
+		t.Fatal("the allocated service is stopped")
+	}
+	released := time.Now()
+	p.release(s, allocs)
+	for deadline := time.Now().Add(5 * time.Second); state(p) != stateStopped; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the service is %s after being idle", state(p))
+		}
+	}
+	if d := time.Since(released); d < r.ServiceIdleTimeout {
+		t.Errorf("the service stopped after being idle for %v, before the idle timeout", d)
+	}
+	if _, _, stops := m.counts(); stops != 1 {
+		t.Errorf("%d stops, want 1", stops)
+	}
+
+	// It is started again when needed
+	allocs, err = p.allocate("Stage.Run", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if starts, _, _ := m.counts(); starts != 2 || state(p) != stateRunning {
+		t.Errorf("%d starts and state %s, want it started again", starts, state(p))
+	}
+	p.release(s, allocs)
+}
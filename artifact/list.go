@@ -1,101 +1,270 @@
 package artifact
 
 import (
+	"fmt"
 	"reflect"
-	"regexp"
-	"strconv"
+	"runtime"
+	"sort"
 	"strings"
+	"time"
 )
 
-var arties []Artifact
+// A Registry holds artifacts, each with a unique and stable ID, and
+// everything declared about their commands: dependencies, input and
+// output files, descriptions, configuration interests and services.
+// Builds using different registries are independent of each other.
+type Registry struct {
+	Options
+	entries        []entry
+	dependencies   map[string][]dependency
+	inputFiles     map[string][]fileDecl
+	outputFiles    map[string][]fileDecl
+	inputVars      map[string][]string
+	descriptions   map[string]string
+	configurations map[string][]*Artifact
+	pool           *ServicePool
+}
+
+type entry struct {
+	id string
+	a  Artifact
+}
+
+// Options control how the commands of a registry are executed
+type Options struct {
+	// IgnoreStamps executes the commands also when they are done
+	IgnoreStamps bool
+
+	// Jobs is the maximum number of commands executed in parallel
+	Jobs int
 
-var configurations map[string][]*Artifact
+	// StampDir is the directory of the stamp files, "" uses the
+	// stamps directory of the workspace
+	StampDir string
+
+	// ServiceTimeout is the longest time a command waits for
+	// services, 0 waits until the services are available
+	ServiceTimeout time.Duration
+
+	// ServiceStartTimeout is the longest time a service may take
+	// to start and become healthy
+	ServiceStartTimeout time.Duration
+
+	// ServiceIdleTimeout is the time a started service may be
+	// unallocated before it is stopped, 0 keeps it running until
+	// the binary exits
+	ServiceIdleTimeout time.Duration
+}
+
+// NewRegistry returns an empty registry, executing as many
+// commands in parallel as there are CPUs
+func NewRegistry() *Registry {
+	r := &Registry{
+		Options: Options{
+			Jobs:                runtime.NumCPU(),
+			ServiceStartTimeout: 5 * time.Minute,
+		},
+		dependencies:   make(map[string][]dependency),
+		inputFiles:     make(map[string][]fileDecl),
+		outputFiles:    make(map[string][]fileDecl),
+		inputVars:      make(map[string][]string),
+		descriptions:   make(map[string]string),
+		configurations: make(map[string][]*Artifact),
+	}
+	r.pool = newServicePool(&r.Options)
+	return r
+}
+
+// Default is the registry used by the package level functions
+var Default = NewRegistry()
 
 // IWantToConfigure tells the system that the self artifact
 // has an interest in the shape of this configuration
-func IWantToConfigure(conf string, self *Artifact) error {
-	configurations[conf] = append(configurations[conf], self)
+func (r *Registry) IWantToConfigure(conf string, self *Artifact) error {
+	r.configurations[conf] = append(r.configurations[conf], self)
 	return nil
 }
 
 // WhoWantToConfigure returns who is interested in the
 // specified configuration
-func WhoWantToConfigure(conf string) []*Artifact {
-	return configurations[conf]
+func (r *Registry) WhoWantToConfigure(conf string) []*Artifact {
+	return r.configurations[conf]
 }
 
-// GetAll artifacts
-func GetAll() []Artifact {
-	return arties
+// IWantToConfigure tells the system that the self artifact
+// has an interest in the shape of this configuration
+func IWantToConfigure(conf string, self *Artifact) error {
+	return Default.IWantToConfigure(conf, self)
 }
 
-func lowName(a Artifact) string {
-	ts := strings.ToLower(reflect.ValueOf(a).Type().String())
-	return strings.Split(ts, ".")[1]
+// WhoWantToConfigure returns who is interested in the
+// specified configuration
+func WhoWantToConfigure(conf string) []*Artifact {
+	return Default.WhoWantToConfigure(conf)
 }
 
-// Version ...
-type Version struct {
-	Major int
-	Minor int
-	Micro int
-	Git   string
+// idSetter is implemented by artifacts embedding BaseArtifact
+type idSetter interface {
+	SetID(string)
 }
 
-// NullVersion ...
-var NullVersion = Version{Major: 0, Minor: 0, Micro: 0, Git: ""}
+// Add registers the artifacts. An artifact without an ID gets one,
+// the type name followed by the version for Versioned artifacts,
+// like "Gcc@=1.2.0". Add panics if the ID is already registered.
+func (r *Registry) Add(arties ...Artifact) {
+	for _, a := range arties {
+		id := a.ID()
+		if id == "" {
+			id = Name(a)
+			if va, ok := a.(Versioned); ok {
+				id += "@=" + va.Version().String()
+			}
+			if s, ok := a.(idSetter); ok {
+				s.SetID(id)
+			}
+		}
+		if _, ok := r.Lookup(id); ok {
+			panic(fmt.Sprintf("artifact %s is already registered", id))
+		}
+		r.entries = append(r.entries, entry{id: id, a: a})
+	}
+}
 
-var versionRegExp = regexp.MustCompile(`^[vV]([0-9]+)[vV]*([0-9]*)[vV]*([0-9]*)`)
-var gitRegExp = regexp.MustCompile(`^[vV]git[vV]([a-z0-9]*)[vV]`)
+// All returns the artifacts in the order they were added
+func (r *Registry) All() []Artifact {
+	res := make([]Artifact, 0, len(r.entries))
+	for _, e := range r.entries {
+		res = append(res, e.a)
+	}
+	return res
+}
 
-func getVersion(str string) Version {
-	vre := versionRegExp.FindStringSubmatch(str)
-	if vre != nil {
-		minor := 0
-		micro := 0
-		major, _ := strconv.Atoi(vre[1])
-		if len(vre) > 2 {
-			minor, _ = strconv.Atoi(vre[2])
+// ID returns the ID the artifact is registered with, or ""
+func (r *Registry) ID(a Artifact) string {
+	for _, e := range r.entries {
+		if same(e.a, a) {
+			return e.id
 		}
-		if len(vre) > 3 {
-			micro, _ = strconv.Atoi(vre[3])
-		}
-		return Version{Major: major, Minor: minor, Micro: micro, Git: ""}
 	}
-	gre := gitRegExp.FindStringSubmatch(str)
-	if gre != nil {
-		return Version{Major: 0, Minor: 0, Micro: 0, Git: gre[1]}
+	return ""
+}
+
+// same tells if a and b are the same artifact
+func same(a, b Artifact) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// Lookup returns the artifact registered with exactly the ID
+func (r *Registry) Lookup(id string) (Artifact, bool) {
+	for _, e := range r.entries {
+		if e.id == id {
+			return e.a, true
+		}
 	}
-	return NullVersion
+	return nil, false
 }
 
-// Find artifacts given a search criteria
+// Find artifacts given a search criteria, matching the type name
+// without regard to case, either exactly or followed by a version.
 // gcc would match GccV1, gcc, GCC, GCCV2
 // but not gccser
-func Find(cond string) []*Artifact {
+func (r *Registry) Find(cond string) []Artifact {
 	condLow := strings.ToLower(cond)
-	var res []*Artifact
-	for _, a := range arties {
-		aType := lowName(a)
+	var res []Artifact
+	for _, e := range r.entries {
+		aType := strings.ToLower(Name(e.a))
 		// Check if prefix match
 		if !strings.HasPrefix(aType, condLow) {
 			continue
 		}
 		// Check for exact match
 		if aType == condLow {
-			res = append(res, &a)
+			res = append(res, e.a)
 			continue
 		}
 		// Check if versioned
 		rest := strings.TrimPrefix(aType, condLow)
 		if getVersion(rest) != NullVersion {
-			res = append(res, &a)
+			res = append(res, e.a)
+		}
+	}
+	return res
+}
+
+// FindVersion returns the artifacts found for cond with a version
+// satisfying the constraint, highest version first. Stable versions
+// come before prereleases and git commits. A nil constraint is
+// satisfied by all versions.
+func (r *Registry) FindVersion(cond string, c *Constraint) []Artifact {
+	var res []Artifact
+	for _, a := range r.Find(cond) {
+		if c == nil || c.Matches(GetVersion(a)) {
+			res = append(res, a)
 		}
 	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return higher(GetVersion(res[i]), GetVersion(res[j]))
+	})
 	return res
 }
 
+// GetAll artifacts
+func GetAll() []Artifact {
+	return Default.All()
+}
+
+// QualifiedName is the ID of the artifact, used as the name
+// of the artifact in canonical commands
+func (r *Registry) QualifiedName(a Artifact) string {
+	return r.ID(a)
+}
+
+// QualifiedName is the ID of the artifact in the default
+// registry, see Registry.QualifiedName
+func QualifiedName(a Artifact) string {
+	return Default.QualifiedName(a)
+}
+
+// Name returns the type name of the artifact, as
+// used in commands like "PrintArtifact.Print"
+func Name(a Artifact) string {
+	return reflect.Indirect(reflect.ValueOf(a)).Type().Name()
+}
+
+// GetVersion returns the version of the artifact. It is the one
+// declared by a Versioned artifact, or else the version encoded
+// in the type name, like 1.0.0 for VirtualBoxDockerMachineV1v0v0
+func GetVersion(a Artifact) Version {
+	if va, ok := a.(Versioned); ok {
+		return va.Version()
+	}
+	_, v := splitVersion(Name(a))
+	return v
+}
+
+// splitVersion splits a type name into the base name and the
+// version encoded in it, like VirtualBoxDockerMachine and 1.0.0
+func splitVersion(name string) (string, Version) {
+	for i := 1; i < len(name); i++ {
+		if name[i] != 'v' && name[i] != 'V' {
+			continue
+		}
+		if v := getVersion(name[i:]); v != NullVersion {
+			return name[:i], v
+		}
+	}
+	return name, NullVersion
+}
+
+// Find artifacts given a search criteria, see Registry.Find
+func Find(cond string) []Artifact {
+	return Default.Find(cond)
+}
+
 // Add one or more Artifacts
 func Add(a ...Artifact) {
-	arties = append(arties, a...)
+	Default.Add(a...)
 }
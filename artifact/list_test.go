@@ -0,0 +1,149 @@
+package artifact
+
+import (
+	"reflect"
+	"testing"
+)
+
+type Gcc struct{ BaseArtifact }
+type GccV1 struct{ BaseArtifact }
+type GCCV2 struct{ BaseArtifact }
+type GccV2v1v3 struct{ BaseArtifact }
+type GccVgitVabc123V struct{ BaseArtifact }
+type Gccser struct{ BaseArtifact }
+type Clang struct {
+	BaseArtifact
+	version string
+}
+
+func (c *Clang) Version() Version { return MustParseVersion(c.version) }
+
+func testRegistry() *Registry {
+	r := NewRegistry()
+	r.Add(new(Gcc), new(GccV1), new(GCCV2), new(GccV2v1v3), new(GccVgitVabc123V), new(Gccser),
+		&Clang{version: "9.0.1"}, &Clang{version: "10.0.0-rc.1"})
+	return r
+}
+
+func TestFind(t *testing.T) {
+	r := testRegistry()
+	tests := []struct {
+		cond string
+		want []string
+	}{
+		// Exact matches and versions following the name
+		{"gcc", []string{"Gcc", "GccV1", "GCCV2", "GccV2v1v3", "GccVgitVabc123V"}},
+		// The case does not matter
+		{"GCC", []string{"Gcc", "GccV1", "GCCV2", "GccV2v1v3", "GccVgitVabc123V"}},
+		{"gccv1", []string{"GccV1"}},
+		{"gccser", []string{"Gccser"}},
+		// A name that is not followed by a version does not match
+		{"gccse", nil},
+		{"gc", nil},
+		{"gccv", nil},
+		// A version prefix matches the longer versions
+		{"gccv2", []string{"GCCV2", "GccV2v1v3"}},
+		// Versioned artifacts match by their type name
+		{"clang", []string{"Clang@=9.0.1", "Clang@=10.0.0-rc.1"}},
+		{"rust", nil},
+	}
+	for _, tt := range tests {
+		got := ids(r, r.Find(tt.cond))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Find(%q) = %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
+
+func TestFindReturnsDistinctArtifacts(t *testing.T) {
+	r := testRegistry()
+	found := r.Find("gcc")
+	seen := make(map[Artifact]bool)
+	for _, a := range found {
+		if seen[a] {
+			t.Errorf("Find returned %s more than once", Name(a))
+		}
+		seen[a] = true
+	}
+	if len(seen) != 5 {
+		t.Errorf("Find returned %d distinct artifacts, want 5", len(seen))
+	}
+}
+
+func TestFindVersion(t *testing.T) {
+	r := testRegistry()
+	tests := []struct {
+		cond       string
+		constraint string
+		want       []string
+	}{
+		{"gcc", "", []string{"GccV2v1v3", "GCCV2", "GccV1", "Gcc", "GccVgitVabc123V"}},
+		{"gcc", "^2", []string{"GccV2v1v3", "GCCV2"}},
+		{"gcc", "git:abc", []string{"GccVgitVabc123V"}},
+		{"clang", "", []string{"Clang@=9.0.1", "Clang@=10.0.0-rc.1"}},
+		{"clang", ">=10.0.0-rc.1", []string{"Clang@=10.0.0-rc.1"}},
+		{"clang", ">=10", nil},
+	}
+	for _, tt := range tests {
+		var c *Constraint
+		if tt.constraint != "" {
+			var err error
+			if c, err = ParseConstraint(tt.constraint); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := ids(r, r.FindVersion(tt.cond, c))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FindVersion(%q, %q) = %v, want %v", tt.cond, tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	r := testRegistry()
+	for _, id := range []string{"Gcc", "GCCV2", "Clang@=9.0.1"} {
+		a, ok := r.Lookup(id)
+		if !ok {
+			t.Errorf("Lookup(%q) found nothing", id)
+			continue
+		}
+		if a.ID() != id || r.ID(a) != id {
+			t.Errorf("Lookup(%q) returned an artifact with ID %q", id, r.ID(a))
+		}
+	}
+	for _, id := range []string{"gcc", "Clang"} {
+		if _, ok := r.Lookup(id); ok {
+			t.Errorf("Lookup(%q) found an artifact", id)
+		}
+	}
+}
+
+func TestAddDuplicateID(t *testing.T) {
+	r := NewRegistry()
+	r.Add(new(Gcc))
+	defer func() {
+		if recover() == nil {
+			t.Error("adding a second Gcc did not panic")
+		}
+	}()
+	r.Add(new(Gcc))
+}
+
+func (g *Gcc) Build()   {}
+func (g *GccV1) Build() {}
+
+func TestRegistriesAreIndependent(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	a.Add(new(Gcc), new(GccV1))
+	b.Add(new(Gcc))
+	a.Depends("Gcc.Build", "GccV1.Build")
+	if got := a.Dependencies("Gcc.Build"); !reflect.DeepEqual(got, []string{"GccV1.Build"}) {
+		t.Errorf("Dependencies = %v, want [GccV1.Build]", got)
+	}
+	if got := b.Dependencies("Gcc.Build"); len(got) != 0 {
+		t.Errorf("Dependencies in another registry = %v, want none", got)
+	}
+	if err := b.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -0,0 +1,874 @@
+package artifact
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// A CapacityService declares how many allocations it can have at the
+// same time, like a docker machine running at most 4 containers. Other
+// services can be allocated as long as they are available.
+type CapacityService interface {
+	Capacity() int
+}
+
+// A CheckedService reports failing to be allocated, which Allocate
+// cannot. The pool allocates it with TryAllocate instead, and selects
+// another service for the command when it fails.
+type CheckedService interface {
+	TryAllocate() (int, error)
+}
+
+// An Allocation is a service allocated for a command
+type Allocation struct {
+	Service string    `json:"service"` // the ServiceID
+	Cmd     string    `json:"cmd"`
+	Field   string    `json:"field"`
+	Token   int       `json:"token"`
+	Since   time.Time `json:"since"`
+	Shared  bool      `json:"shared,omitempty"` // by the commands of the artifact, until the build is done
+
+	owner    *pooled
+	target   Artifact // of a shared allocation
+	pending  bool     // until the service is allocated
+	released bool     // once it is being deallocated
+}
+
+// A Waiter is a command waiting for services
+type Waiter struct {
+	Cmd         string    `json:"cmd"`
+	Field       string    `json:"field"` // the field no service is available for
+	Requirement string    `json:"requirement"`
+	Since       time.Time `json:"since"`
+
+	cands map[*pooled]bool // all services the command could get
+}
+
+// A ServiceStatus is the state of a registered service instance
+type ServiceStatus struct {
+	Service     string       `json:"service"`
+	Capacity    int          `json:"capacity"` // 0 is unlimited
+	Available   bool         `json:"available"`
+	State       string       `json:"state,omitempty"` // of a LifecycleService
+	Allocations []Allocation `json:"allocations,omitempty"`
+}
+
+// A PoolStatus is the state of a service pool
+type PoolStatus struct {
+	Pid      int             `json:"pid,omitempty"` // of the build publishing it
+	Services []ServiceStatus `json:"services"`
+	Waiting  []Waiter        `json:"waiting,omitempty"` // the one that has waited the longest first
+}
+
+// A pooled service is a registered service instance
+type pooled struct {
+	service  ServiceAPI
+	name     string // registered with, or ""
+	capacity int
+	allocs   []*Allocation
+	up       bool       // as IsAvailable last answered
+	life     *lifecycle // nil unless a LifecycleService
+}
+
+// available tells if the service can be allocated once more. The
+// caller must hold mu.
+func (s *pooled) available() bool {
+	return (s.capacity == 0 || len(s.allocs) < s.capacity) && s.up
+}
+
+// A ServicePool holds the registered service instances and their
+// allocations. Commands waiting for services are served in the order
+// they started waiting. A command only gets services when no command
+// that waited longer could get the same services. The services are
+// never called while mu is held, so a slow service does not block
+// the pool.
+type ServicePool struct {
+	opts       *Options // of the registry
+	mu         sync.Mutex
+	freed      *sync.Cond // signaled when services are deallocated
+	services   []*pooled
+	queue      []*Waiter
+	shared     map[string]*Allocation // by artifact ID and field, like "Gcc.Machine"
+	fieldLocks map[string]*sync.Mutex // by artifact ID and field, held while a service is injected
+
+	interrupted bool // the build is exiting, nothing is published
+}
+
+func newServicePool(opts *Options) *ServicePool {
+	p := &ServicePool{opts: opts, shared: make(map[string]*Allocation), fieldLocks: make(map[string]*sync.Mutex)}
+	p.freed = sync.NewCond(&p.mu)
+	return p
+}
+
+// register adds a service instance, with the capacity it declares
+func (p *ServicePool) register(name string, si ServiceAPI) {
+	s := &pooled{service: si, name: name, up: si.IsAvailable()}
+	if cs, ok := si.(CapacityService); ok {
+		s.capacity = cs.Capacity()
+	}
+	if _, ok := si.(LifecycleService); ok {
+		s.life = &lifecycle{state: stateStopped}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.services = append(p.services, s)
+}
+
+// Instances returns the registered service instances
+func (p *ServicePool) Instances() []ServiceAPI {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var res []ServiceAPI
+	for _, s := range p.services {
+		res = append(res, s.service)
+	}
+	return res
+}
+
+// Status returns the state of the registered service instances
+// and the commands waiting for them
+func (p *ServicePool) Status() PoolStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status()
+}
+
+// status returns the state of the pool. The caller must hold mu.
+func (p *ServicePool) status() PoolStatus {
+	var res PoolStatus
+	for _, s := range p.services {
+		st := ServiceStatus{Service: p.idOf(s), Capacity: s.capacity, Available: s.available()}
+		if s.life != nil {
+			st.State = s.life.state
+		}
+		for _, al := range s.allocs {
+			st.Allocations = append(st.Allocations, *al)
+		}
+		res.Services = append(res.Services, st)
+	}
+	for _, w := range p.queue {
+		res.Waiting = append(res.Waiting, *w)
+	}
+	return res
+}
+
+// publish writes the state of the pool to the services file of the
+// workspace, for the services command to show, and removes the file
+// when nothing is allocated, waiting or started. The caller must hold mu.
+func (p *ServicePool) publish() {
+	path := workspace.GetServicesFilePath()
+	if path == "" || p.interrupted {
+		return
+	}
+	busy := len(p.queue) > 0
+	for _, s := range p.services {
+		busy = busy || len(s.allocs) > 0 || s.life != nil && s.life.state != stateStopped
+	}
+	if !busy {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %v", path, err)
+		}
+		return
+	}
+	st := p.status()
+	st.Pid = os.Getpid()
+	raw, err := json.MarshalIndent(st, "", "  ")
+	if err == nil {
+		// Replace the file at once, it may be read at any time
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, raw, 0666); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to publish the service allocations: %v", err)
+	}
+}
+
+// ReadPoolStatus reads the state of the service pool published by a
+// build running in the workspace. It is nil if no build is using
+// services, also if the file is left by a build that is no longer
+// running.
+func ReadPoolStatus() (*PoolStatus, error) {
+	path := workspace.GetServicesFilePath()
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st PoolStatus
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	if st.Pid != 0 && !running(st.Pid) {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+// running tells if the process with the pid is running
+func running(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// A NamedService names itself, instead of being identified by its
+// type, like services adapting other processes
+type NamedService interface {
+	ServiceName() string
+}
+
+func serviceName(si ServiceAPI) string {
+	if ns, ok := si.(NamedService); ok {
+		return ns.ServiceName()
+	}
+	return fmt.Sprintf("%T", si)
+}
+
+// baseName returns the name the service is registered with,
+// its NamedService name or its type
+func (s *pooled) baseName() string {
+	if s.name != "" {
+		return s.name
+	}
+	return serviceName(s.service)
+}
+
+// id identifies a service instance by its name or type. The caller
+// must hold mu.
+func (p *ServicePool) id(si ServiceAPI) string {
+	for _, s := range p.services {
+		if reflect.TypeOf(si).Comparable() && s.service == si {
+			return p.idOf(s)
+		}
+	}
+	return serviceName(si)
+}
+
+// idOf identifies a registered service instance by its name. If several
+// instances have the same name they are numbered. The caller must hold mu.
+func (p *ServicePool) idOf(s *pooled) string {
+	name := s.baseName()
+	n, index := 0, 0
+	for _, o := range p.services {
+		if o.baseName() != name {
+			continue
+		}
+		n++
+		if o == s {
+			index = n
+		}
+	}
+	if n > 1 {
+		return fmt.Sprintf("%s#%d", name, index)
+	}
+	return name
+}
+
+// allocate injects a service into each field of the artifact required
+// by the canonical command cmd, and starts the services that are not
+// running. If no registered service satisfies the requirement of a
+// field, an InjectionError is returned, unless the field is optional
+// and left nil.
+func (p *ServicePool) allocate(cmd string, a Artifact) ([]*Allocation, error) {
+	allocs, err := p.reserve(cmd, a)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.start(allocs); err != nil {
+		p.release(a, allocs)
+		return nil, err
+	}
+	return allocs, nil
+}
+
+// reserve allocates and injects a service for each field of the
+// artifact required by the command, and returns them with the shared
+// services it uses again. Either all fields get a service or none, so
+// a command never holds some services while it waits for others. If
+// no candidate is available, reserve waits until services are
+// deallocated, at most the ServiceTimeout of the registry. Candidates
+// unavailable while none of them is allocated, like a license server
+// used by others, are asked again with growing delays.
+func (p *ServicePool) reserve(cmd string, a Artifact) ([]*Allocation, error) {
+	id, meth, err := splitCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	all := commandRequirements(a, meth)
+	if len(all) == 0 {
+		return nil, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var w *Waiter
+	defer func() {
+		if w != nil {
+			p.dequeue(w)
+		}
+	}()
+	timeout := p.opts.ServiceTimeout
+	if timeout > 0 {
+		// Wake up the waiting command when the time is out
+		t := time.AfterFunc(timeout, func() {
+			p.mu.Lock()
+			p.freed.Broadcast()
+			p.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	var poll *time.Timer
+	var delay time.Duration
+	defer func() {
+		if poll != nil {
+			poll.Stop()
+		}
+	}()
+	start := time.Now()
+	failed := make(map[*pooled]error) // by TryAllocate
+	for {
+		// Shared services already allocated for the artifact are used again
+		reused, reqs, pending := p.split(id, all)
+		if pending {
+			// Another command of the artifact is getting the service
+			p.freed.Wait()
+			continue
+		}
+		if len(reqs) == 0 {
+			return reused, nil
+		}
+		p.mu.Unlock()
+		candsOf, missing, err := p.match(a, reqs)
+		if err == nil {
+			p.probe(candsOf)
+		}
+		p.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+		if len(missing) > 0 {
+			return nil, missing[0]
+		}
+		for i, cs := range candsOf {
+			candsOf[i] = nil
+			for _, c := range cs {
+				if failed[c] == nil {
+					candsOf[i] = append(candsOf[i], c)
+				}
+			}
+			if len(candsOf[i]) == 0 && len(cs) > 0 && !reqs[i].Optional {
+				return nil, fmt.Errorf("%s.%s: %v", Name(a), reqs[i].Field, failed[cs[0]])
+			}
+		}
+		if again, _, pending := p.split(id, all); pending || len(again) != len(reused) {
+			// A shared service was allocated meanwhile
+			continue
+		}
+		cands := make(map[*pooled]bool)
+		for _, cs := range candsOf {
+			for _, c := range cs {
+				cands[c] = true
+			}
+		}
+		waitFor := reqs[0]
+		busy := false // the candidates are busy outside the pool
+		if !p.waitedLonger(w, cands) {
+			allocs, blocked := p.tryAllocate(cmd, reqs, candsOf)
+			if blocked < 0 {
+				for _, al := range allocs {
+					if al.Shared {
+						al.target = a
+						p.shared[id+"."+al.Field] = al
+					}
+				}
+				p.mu.Unlock()
+				tokens, s, err := p.claim(allocs)
+				p.mu.Lock()
+				if err != nil {
+					log.Printf("Failed to allocate service %s: %v", p.idOf(s), err)
+					failed[s] = err
+					s.up = false
+					p.unclaim(id, allocs)
+					continue
+				}
+				var bindings []ServiceBinding
+				for i, al := range allocs {
+					al.Token, al.pending = tokens[i], false
+					bindings = append(bindings, ServiceBinding{Field: al.Field, Service: al.owner.service, Shared: al.Shared})
+				}
+				inject(a, bindings)
+				p.freed.Broadcast()
+				if w == nil {
+					p.publish()
+				}
+				return append(allocs, reused...), nil
+			}
+			waitFor = reqs[blocked]
+			// Waiting would never end if nothing is deallocated before
+			// the build is done
+			switch holders := holders(candsOf[blocked]); {
+			case holders == nil:
+			case len(holders) == 0:
+				busy = true
+			default:
+				return nil, fmt.Errorf("no service available for %s.%s %q, they are shared by %s until the build is done",
+					Name(a), waitFor.Field, waitFor.Requirement, strings.Join(holders, ", "))
+			}
+		} else if w != nil {
+			// Still behind other commands, keep what it waited for
+			waitFor.Field, waitFor.Requirement = w.Field, w.Requirement
+		}
+		queued := w == nil
+		if queued {
+			w = &Waiter{Cmd: cmd, Since: start}
+			p.queue = append(p.queue, w)
+		}
+		w.Field, w.Requirement, w.cands = waitFor.Field, waitFor.Requirement, cands
+		if queued {
+			p.publish()
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return nil, fmt.Errorf("timed out after %v waiting for a service for %s.%s %q",
+				timeout, Name(a), w.Field, w.Requirement)
+		}
+		if busy {
+			// Nothing is deallocated to wake up the command, so
+			// it asks the services again later
+			delay = backoff(delay)
+			if poll != nil {
+				poll.Stop()
+			}
+			poll = time.AfterFunc(delay, func() {
+				p.mu.Lock()
+				p.freed.Broadcast()
+				p.mu.Unlock()
+			})
+		}
+		p.freed.Wait()
+	}
+}
+
+// backoff returns the time to wait before checking a service again,
+// twice the delay, from 100 milliseconds to at most 5 seconds
+func backoff(delay time.Duration) time.Duration {
+	switch {
+	case delay <= 0:
+		return 100 * time.Millisecond
+	case 2*delay > 5*time.Second:
+		return 5 * time.Second
+	}
+	return 2 * delay
+}
+
+// split returns the shared services already allocated for the
+// artifact with the ID, and the requirements that need a service.
+// pending tells if a shared service is still being allocated for
+// another command. The caller must hold mu.
+func (p *ServicePool) split(id string, all []ServiceRequirement) ([]*Allocation, []ServiceRequirement, bool) {
+	var reused []*Allocation
+	var reqs []ServiceRequirement
+	pending := false
+	for _, req := range all {
+		if al, ok := p.shared[id+"."+req.Field]; ok && req.Shared {
+			reused = append(reused, al)
+			pending = pending || al.pending
+		} else {
+			reqs = append(reqs, req)
+		}
+	}
+	return reused, reqs, pending
+}
+
+// match returns the candidates for each of the requirements of the
+// artifact, and an InjectionError for each required field that no
+// registered service satisfies. An optional field without candidates
+// is left nil. The caller must not hold mu, services without
+// properties are asked if they satisfy the requirements.
+func (p *ServicePool) match(a Artifact, reqs []ServiceRequirement) ([][]*pooled, []*InjectionError, error) {
+	p.mu.Lock()
+	services := append([]*pooled(nil), p.services...)
+	ids := make(map[*pooled]string)
+	for _, s := range services {
+		ids[s] = p.idOf(s)
+	}
+	p.mu.Unlock()
+	candsOf := make([][]*pooled, len(reqs))
+	var missing []*InjectionError
+	for i, req := range reqs {
+		cs, rejected, err := candidates(req, services, ids)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s.%s: %v", Name(a), req.Field, err)
+		}
+		if len(cs) == 0 && !req.Optional {
+			missing = append(missing, &InjectionError{Artifact: Name(a), Field: req.Field, Type: req.Type.String(),
+				Requirement: req.Requirement, Service: req.Name, Rejected: rejected})
+		}
+		candsOf[i] = cs
+	}
+	return candsOf, missing, nil
+}
+
+// candidates returns the services, identified by ids, that implement
+// the interface of the requirement, have the requested name and satisfy
+// the requirement, and why the others were rejected. The requirement
+// is matched against the properties of a PropertyService, and passed
+// to Satisfies of other services.
+func candidates(req ServiceRequirement, services []*pooled, ids map[*pooled]string) ([]*pooled, []Rejection, error) {
+	parsed, err := ParseRequirement(req.Requirement)
+	var res []*pooled
+	var rejected []Rejection
+	for _, s := range services {
+		reason := ""
+		if !reflect.ValueOf(s.service).Type().Implements(req.Type) {
+			reason = fmt.Sprintf("does not implement %s", req.Type)
+		} else if req.Name != "" && req.Name != s.baseName() && req.Name != ids[s] {
+			reason = fmt.Sprintf("is not named %q", req.Name)
+		} else if req.Name != "" && req.Requirement == "" {
+			// Only the name is requested
+		} else if ps, ok := s.service.(PropertyService); ok {
+			if err != nil {
+				return nil, nil, err
+			}
+			if props := ps.Properties(); !parsed.Matches(props) {
+				reason = fmt.Sprintf("properties %s do not satisfy the requirement", props)
+			}
+		} else if !s.service.Satisfies(req.Requirement) {
+			reason = "does not satisfy the requirement"
+		}
+		if reason == "" {
+			res = append(res, s)
+		} else {
+			rejected = append(rejected, Rejection{Service: ids[s], Reason: reason})
+		}
+	}
+	return res, rejected, nil
+}
+
+// checkRequirement parses the requirement if it is matched against the
+// properties of a registered service, without asking other services
+func (p *ServicePool) checkRequirement(req ServiceRequirement) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.services {
+		if _, ok := s.service.(PropertyService); ok && reflect.ValueOf(s.service).Type().Implements(req.Type) {
+			_, err := ParseRequirement(req.Requirement)
+			return err
+		}
+	}
+	return nil
+}
+
+// probe asks the candidates if they are available, for best to select
+// among them. The caller must not hold mu.
+func (p *ServicePool) probe(candsOf [][]*pooled) {
+	up := make(map[*pooled]bool)
+	for _, cs := range candsOf {
+		for _, c := range cs {
+			if _, ok := up[c]; !ok {
+				up[c] = c.service.IsAvailable()
+			}
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c, ok := range up {
+		c.up = ok
+	}
+}
+
+// best returns the available service with the highest score,
+// the first one if several have the same score, or nil. The
+// caller must hold mu.
+func best(services []*pooled) *pooled {
+	var res *pooled
+	bestScore := 0
+	for _, s := range services {
+		if !s.available() {
+			continue
+		}
+		score := 0
+		if ss, ok := s.service.(ScoredService); ok {
+			score = ss.Score()
+		}
+		if res == nil || score > bestScore {
+			res, bestScore = s, score
+		}
+	}
+	return res
+}
+
+// waitedLonger tells if a command that has waited longer than w
+// could get any of the services. w is nil for a command that
+// has not started waiting. The caller must hold mu.
+func (p *ServicePool) waitedLonger(w *Waiter, cands map[*pooled]bool) bool {
+	for _, other := range p.queue {
+		if other == w {
+			return false
+		}
+		for c := range other.cands {
+			if cands[c] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tryAllocate records an allocation of the best available candidate
+// for each of the requirements, to be claimed from the services. If a
+// requirement has candidates but none is available, nothing is
+// allocated and its index is returned, else -1. The caller must hold mu.
+func (p *ServicePool) tryAllocate(cmd string, reqs []ServiceRequirement, candsOf [][]*pooled) ([]*Allocation, int) {
+	var allocs []*Allocation
+	for i, req := range reqs {
+		selected := best(candsOf[i])
+		if len(candsOf[i]) > 0 && selected == nil {
+			p.forget(allocs)
+			return nil, i
+		}
+		if selected != nil {
+			al := &Allocation{Service: p.idOf(selected), Cmd: cmd, Field: req.Field, Shared: req.Shared,
+				Since: time.Now(), owner: selected, pending: true}
+			selected.allocs = append(selected.allocs, al)
+			p.idleFrom(selected)
+			allocs = append(allocs, al)
+		}
+	}
+	return allocs, -1
+}
+
+// claim allocates the services of the allocations and returns their
+// tokens. If a CheckedService fails, the services allocated so far are
+// deallocated, and the failing service is returned with the error. The
+// caller must not hold mu.
+func (p *ServicePool) claim(allocs []*Allocation) ([]int, *pooled, error) {
+	tokens := make([]int, len(allocs))
+	for i, al := range allocs {
+		s := al.owner
+		cs, ok := s.service.(CheckedService)
+		if !ok {
+			tokens[i] = s.service.Allocate()
+			continue
+		}
+		token, err := cs.TryAllocate()
+		if err != nil {
+			for j := range allocs[:i] {
+				allocs[j].owner.service.Deallocate(tokens[j])
+			}
+			return nil, s, err
+		}
+		tokens[i] = token
+	}
+	return tokens, nil, nil
+}
+
+// unclaim removes the allocations of the artifact with the ID that
+// failed to be claimed. The caller must hold mu.
+func (p *ServicePool) unclaim(id string, allocs []*Allocation) {
+	for _, al := range allocs {
+		if al.Shared {
+			delete(p.shared, id+"."+al.Field)
+		}
+	}
+	p.forget(allocs)
+	p.freed.Broadcast()
+}
+
+// holders returns the fields holding the shared allocations of the
+// services, like "Gcc.Machine", if they have no other allocations. It
+// is nil if any allocation is deallocated when its command is done.
+// The caller must hold mu.
+func holders(services []*pooled) []string {
+	res := []string{}
+	for _, s := range services {
+		for _, al := range s.allocs {
+			if !al.Shared {
+				return nil
+			}
+			id, _, _ := splitCommand(al.Cmd)
+			res = append(res, id+"."+al.Field)
+		}
+	}
+	return res
+}
+
+func (p *ServicePool) dequeue(w *Waiter) {
+	for i, other := range p.queue {
+		if other == w {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			break
+		}
+	}
+	// The next command in the queue may get the services now
+	p.freed.Broadcast()
+	p.publish()
+}
+
+// release deallocates the services allocated for a command of the
+// artifact and clears the fields they were injected into. Shared
+// services are kept until the build is done.
+func (p *ServicePool) release(a Artifact, allocs []*Allocation) {
+	var own []*Allocation
+	for _, al := range allocs {
+		if !al.Shared {
+			own = append(own, al)
+		}
+	}
+	if len(own) == 0 {
+		return
+	}
+	p.mu.Lock()
+	v := reflect.ValueOf(a).Elem()
+	for _, al := range own {
+		f := v.FieldByName(al.Field)
+		f.Set(reflect.Zero(f.Type()))
+	}
+	p.mu.Unlock()
+	p.deallocate(own)
+}
+
+// releaseShared deallocates the shared services when the build is
+// done, and clears the fields they were injected into
+func (p *ServicePool) releaseShared() {
+	p.mu.Lock()
+	var allocs []*Allocation
+	for key, al := range p.shared {
+		f := reflect.ValueOf(al.target).Elem().FieldByName(al.Field)
+		f.Set(reflect.Zero(f.Type()))
+		allocs = append(allocs, al)
+		delete(p.shared, key)
+	}
+	p.mu.Unlock()
+	p.deallocate(allocs)
+}
+
+// Interrupt deallocates all services and stops the started ones, when
+// the build is interrupted while commands still use them. The services
+// file of the workspace is removed, and not written again.
+func (p *ServicePool) Interrupt() error {
+	p.mu.Lock()
+	p.interrupted = true
+	var allocs []*Allocation
+	for _, s := range p.services {
+		for _, al := range s.allocs {
+			if !al.pending && !al.released {
+				al.released = true
+				allocs = append(allocs, al)
+			}
+		}
+	}
+	if path := workspace.GetServicesFilePath(); path != "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %v", path, err)
+		}
+	}
+	p.mu.Unlock()
+	for _, al := range allocs {
+		al.owner.service.Deallocate(al.Token)
+	}
+	p.mu.Lock()
+	p.forget(allocs)
+	p.mu.Unlock()
+	return p.Stop()
+}
+
+// deallocate the services and wake up commands waiting for
+// services. The caller must not hold mu.
+func (p *ServicePool) deallocate(allocs []*Allocation) {
+	p.mu.Lock()
+	var own []*Allocation
+	for _, al := range allocs {
+		// Interrupt may already be deallocating it
+		if !al.released {
+			al.released = true
+			own = append(own, al)
+		}
+	}
+	p.mu.Unlock()
+	if len(own) == 0 {
+		return
+	}
+	for _, al := range own {
+		al.owner.service.Deallocate(al.Token)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forget(own)
+	p.freed.Broadcast()
+	p.publish()
+}
+
+// forget removes the allocations from their services. The caller
+// must hold mu.
+func (p *ServicePool) forget(allocs []*Allocation) {
+	for _, al := range allocs {
+		s := al.owner
+		for i, other := range s.allocs {
+			if other == al {
+				s.allocs = append(s.allocs[:i], s.allocs[i+1:]...)
+				break
+			}
+		}
+		p.idleFrom(s)
+	}
+}
+
+// lockFields serializes the commands of an artifact that get services
+// injected into the same fields, since the fields are shared by its
+// commands. Fields of shared services are not locked, they keep their
+// service until the build is done. It returns the function unlocking
+// the fields.
+func (p *ServicePool) lockFields(cmd string, a Artifact) func() {
+	id, meth, err := splitCommand(cmd)
+	if err != nil {
+		return func() {}
+	}
+	var keys []string
+	for _, req := range commandRequirements(a, meth) {
+		if !req.Shared {
+			keys = append(keys, id+"."+req.Field)
+		}
+	}
+	// Always locking in the same order avoids deadlocks
+	sort.Strings(keys)
+	var locks []*sync.Mutex
+	p.mu.Lock()
+	for _, key := range keys {
+		m, ok := p.fieldLocks[key]
+		if !ok {
+			m = new(sync.Mutex)
+			p.fieldLocks[key] = m
+		}
+		locks = append(locks, m)
+	}
+	p.mu.Unlock()
+	for _, m := range locks {
+		m.Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
@@ -0,0 +1,548 @@
+package artifact
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// A capMachine can be allocated capacity times at once, and
+// records how many allocations it had at the same time
+type capMachine struct {
+	propMachine
+	capacity int
+
+	mu        sync.Mutex
+	allocated int
+	most      int
+}
+
+func newCapMachine(host string, capacity int, score int) *capMachine {
+	return &capMachine{propMachine: propMachine{plainMachine{host}, Properties{"os": "linux", "cpus": "8"}, score},
+		capacity: capacity}
+}
+
+func (m *capMachine) Capacity() int { return m.capacity }
+
+func (m *capMachine) Allocate() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.allocated++
+	if m.allocated > m.most {
+		m.most = m.allocated
+	}
+	return m.allocated
+}
+
+func (m *capMachine) Deallocate(token int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.allocated--
+}
+
+// A Stage runs on a machine for a while
+type Stage struct {
+	BaseArtifact
+	Machine Machine `requirement:"os=linux"`
+	host    string
+}
+
+func (s *Stage) Run() {
+	s.host = s.Machine.Host()
+	time.Sleep(20 * time.Millisecond)
+}
+
+// stages adds n stages with the IDs Stage1, Stage2 and so on,
+// and returns their Run commands
+func stages(r *Registry, n int) []string {
+	var cmds []string
+	for i := 1; i <= n; i++ {
+		s := new(Stage)
+		s.SetID(fmt.Sprintf("Stage%d", i))
+		r.Add(s)
+		cmds = append(cmds, s.ID()+".Run")
+	}
+	return cmds
+}
+
+// waitQueued waits until n commands wait for services
+func waitQueued(t *testing.T, p *ServicePool, n int) {
+	for i := 0; i < 200; i++ {
+		if len(p.Status().Waiting) == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d commands waiting, want %d", len(p.Status().Waiting), n)
+}
+
+func TestPoolCapacity(t *testing.T) {
+	tests := []struct {
+		capacities []int // of the services, 0 is unlimited
+		want       []int // the most allocations each service had at once
+	}{
+		{[]int{1}, []int{1}},
+		{[]int{2}, []int{2}},
+		{[]int{0}, []int{5}},
+		{[]int{1, 2}, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		r := unstampedRegistry()
+		targets := stages(r, 5)
+		var machines []*capMachine
+		for i, c := range tt.capacities {
+			m := newCapMachine(fmt.Sprint(i), c, 0)
+			machines = append(machines, m)
+			r.RegisterServiceInstance(m)
+		}
+		if err := r.newPlan(nil, targets...).execute(len(targets)); err != nil {
+			t.Fatal(err)
+		}
+		for i, m := range machines {
+			if m.most != tt.want[i] {
+				t.Errorf("capacities %v: service %d had %d allocations at once, want %d",
+					tt.capacities, i, m.most, tt.want[i])
+			}
+			if m.allocated != 0 {
+				t.Errorf("capacities %v: service %d still has %d allocations", tt.capacities, i, m.allocated)
+			}
+		}
+	}
+}
+
+// A Lint runs on windows machines
+type Lint struct {
+	BaseArtifact
+	Machine Machine `requirement:"os=windows"`
+}
+
+func (l *Lint) Run() {}
+
+func TestPoolQueue(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterServiceInstance(newCapMachine("linux", 1, 0))
+	windows := newCapMachine("windows", 1, 0)
+	windows.props = Properties{"os": "windows"}
+	r.RegisterServiceInstance(windows)
+	p := r.Services()
+
+	holder := new(Stage)
+	held, err := p.allocate("Holder.Run", holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string)
+	var done sync.WaitGroup
+	wait := func(cmd string) {
+		defer done.Done()
+		s := new(Stage)
+		allocs, err := p.allocate(cmd, s)
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- cmd
+		p.release(s, allocs)
+	}
+	cmds := []string{"First.Run", "Second.Run", "Third.Run"}
+	for i, cmd := range cmds {
+		done.Add(1)
+		go wait(cmd)
+		waitQueued(t, p, i+1)
+	}
+	var waiting []string
+	for _, w := range p.Status().Waiting {
+		waiting = append(waiting, w.Cmd)
+	}
+	if fmt.Sprint(waiting) != fmt.Sprint(cmds) {
+		t.Errorf("waiting %v, want %v", waiting, cmds)
+	}
+
+	// A command needing other services does not wait behind them
+	lint := new(Lint)
+	allocs, err := p.allocate("Lint.Run", lint)
+	if err != nil || lint.Machine == nil || lint.Machine.Host() != "windows" {
+		t.Errorf("Lint got %v, %v, want the windows machine at once", lint.Machine, err)
+	}
+	p.release(lint, allocs)
+
+	// The waiting commands get the service in the order they started waiting
+	p.release(holder, held)
+	for _, want := range cmds {
+		if g := <-got; g != want {
+			t.Errorf("%s got the service, want %s", g, want)
+		}
+	}
+	done.Wait()
+	if st := p.Status(); len(st.Waiting) != 0 || len(st.Services[0].Allocations) != 0 {
+		t.Errorf("status after the commands %+v, want no allocations or waiting commands", st)
+	}
+}
+
+func TestPoolScore(t *testing.T) {
+	r := NewRegistry()
+	for _, m := range []*capMachine{
+		newCapMachine("low", 1, 1),
+		newCapMachine("high", 1, 5),
+		newCapMachine("also high", 1, 5),
+		newCapMachine("unlimited", 0, 0),
+	} {
+		r.RegisterServiceInstance(m)
+	}
+	p := r.Services()
+	// Each allocation is kept, so the best services fill up
+	for _, want := range []string{"high", "also high", "low", "unlimited", "unlimited"} {
+		s := new(Stage)
+		if _, err := p.allocate("Stage.Run", s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Machine.Host() != want {
+			t.Errorf("got %q, want %q", s.Machine.Host(), want)
+		}
+	}
+}
+
+type OnBig struct {
+	BaseArtifact
+	Machine Machine `service:"big"`
+}
+
+type OnSecondBig struct {
+	BaseArtifact
+	Machine Machine `service:"big#2"`
+}
+
+type OnFastBig struct {
+	BaseArtifact
+	Machine Machine `service:"big" requirement:"cpus >= 16"`
+}
+
+type OnSmall struct {
+	BaseArtifact
+	Machine Machine `service:"small"`
+}
+
+func (a *OnBig) Run()       {}
+func (a *OnSecondBig) Run() {}
+func (a *OnFastBig) Run()   {}
+func (a *OnSmall) Run()     {}
+
+func TestPoolNamedServices(t *testing.T) {
+	r := NewRegistry()
+	first, second := newCapMachine("first", 0, 0), newCapMachine("second", 0, 0)
+	second.props = Properties{"os": "linux", "cpus": "32"}
+	unnamed := newCapMachine("unnamed", 0, 10)
+	r.RegisterNamedServiceInstance("big", first)
+	r.RegisterNamedServiceInstance("big", second)
+	r.RegisterServiceInstance(unnamed)
+
+	for s, want := range map[ServiceAPI]string{first: "big#1", second: "big#2", unnamed: "*artifact.capMachine"} {
+		if id := r.ServiceID(s); id != want {
+			t.Errorf("ServiceID = %q, want %q", id, want)
+		}
+	}
+
+	tests := []struct {
+		a    Artifact
+		want string // the host injected, or "" if none is
+	}{
+		{new(OnBig), "first"},
+		{new(OnSecondBig), "second"},
+		{new(OnFastBig), "second"},
+		{new(OnSmall), ""},
+	}
+	p := r.Services()
+	for _, tt := range tests {
+		allocs, err := p.allocate(Name(tt.a)+".Run", tt.a)
+		field := reflect.ValueOf(tt.a).Elem().FieldByName("Machine").Interface()
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s got %v injected, want an error", Name(tt.a), field)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s: %v", Name(tt.a), err)
+		case tt.want != "" && field.(Machine).Host() != tt.want:
+			t.Errorf("%s got %q injected, want %q", Name(tt.a), field.(Machine).Host(), tt.want)
+		}
+		p.release(tt.a, allocs)
+	}
+}
+
+func TestPoolTimeout(t *testing.T) {
+	r := NewRegistry()
+	r.ServiceTimeout = 50 * time.Millisecond
+	r.RegisterServiceInstance(newCapMachine("only", 1, 0))
+	p := r.Services()
+	holder := new(Stage)
+	held, err := p.allocate("Holder.Run", holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	s := new(Stage)
+	_, err = p.allocate("Stage.Run", s)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Errorf("allocate = %v, want it to time out", err)
+	}
+	if d := time.Since(start); d < r.ServiceTimeout {
+		t.Errorf("allocate returned after %v, before the timeout", d)
+	}
+	if s.Machine != nil {
+		t.Error("a service is injected after the timeout")
+	}
+	if w := p.Status().Waiting; len(w) != 0 {
+		t.Errorf("%v still waiting after the timeout", w)
+	}
+
+	// Once the service is released, it can be allocated again
+	p.release(holder, held)
+	allocs, err := p.allocate("Stage.Run", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.release(s, allocs)
+}
+
+// A bootingMachine is not available until it is ready
+type bootingMachine struct {
+	*capMachine
+	ready time.Time
+}
+
+func (m *bootingMachine) IsAvailable() bool { return time.Now().After(m.ready) }
+
+func TestPoolUnavailableService(t *testing.T) {
+	r := NewRegistry()
+	r.ServiceTimeout = 5 * time.Second
+	r.RegisterServiceInstance(&bootingMachine{newCapMachine("booting", 0, 0), time.Now().Add(300 * time.Millisecond)})
+	p := r.Services()
+
+	// The command waits for the service to become available
+	start := time.Now()
+	done := make(chan error)
+	s := new(Stage)
+	go func() {
+		allocs, err := p.allocate("Stage.Run", s)
+		if err == nil {
+			s.host = s.Machine.Host()
+		}
+		p.release(s, allocs)
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if w := p.Status().Waiting; len(w) != 1 || w[0].Cmd != "Stage.Run" {
+		t.Errorf("waiting %+v, want Stage.Run waiting for the booting service", w)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("allocate returned after %v, before the service is ready", d)
+	}
+	if s.host != "booting" {
+		t.Errorf("got %q injected, want the booting service", s.host)
+	}
+
+	// A service not ready in time fails the command
+	r = NewRegistry()
+	r.ServiceTimeout = 200 * time.Millisecond
+	r.RegisterServiceInstance(&bootingMachine{newCapMachine("booting", 0, 0), time.Now().Add(time.Hour)})
+	if _, err := r.Services().allocate("Stage.Run", new(Stage)); err == nil ||
+		!strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("allocate = %v, want it to time out", err)
+	}
+}
+
+// A slowMachine takes until gate is closed to tell if it
+// satisfies a requirement naming its host
+type slowMachine struct {
+	plainMachine
+	asked chan bool
+	gate  chan bool
+}
+
+func (m *slowMachine) Satisfies(requirement string) bool {
+	if requirement != "host="+m.host {
+		return false
+	}
+	m.asked <- true
+	<-m.gate
+	return true
+}
+
+func TestPoolSlowService(t *testing.T) {
+	r := NewRegistry()
+	slow := &slowMachine{plainMachine{"prod"}, make(chan bool), make(chan bool)}
+	r.RegisterServiceInstance(slow)
+	r.RegisterServiceInstance(newCapMachine("linux", 1, 0))
+	p := r.Services()
+
+	deployed := make(chan error)
+	go func() {
+		d := new(Deploy)
+		allocs, err := p.allocate("Deploy.Run", d)
+		p.release(d, allocs)
+		deployed <- err
+	}()
+	<-slow.asked
+
+	// While the slow service answers, other commands get services
+	done := make(chan error)
+	go func() {
+		s := new(Stage)
+		allocs, err := p.allocate("Stage.Run", s)
+		p.release(s, allocs)
+		p.Status()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pool is blocked by the slow service")
+	}
+	close(slow.gate)
+	if err := <-deployed; err != nil {
+		t.Error(err)
+	}
+}
+
+// A failingMachine fails to be allocated
+type failingMachine struct {
+	*capMachine
+}
+
+func (m *failingMachine) TryAllocate() (int, error) {
+	return 0, fmt.Errorf("%s is broken", m.host)
+}
+
+func TestPoolFailedAllocation(t *testing.T) {
+	r := NewRegistry()
+	broken := &failingMachine{newCapMachine("broken", 0, 10)}
+	r.RegisterServiceInstance(broken)
+	r.RegisterServiceInstance(newCapMachine("working", 0, 0))
+	p := r.Services()
+
+	// Another service is selected instead
+	s := new(Stage)
+	allocs, err := p.allocate("Stage.Run", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Machine.Host() != "working" {
+		t.Errorf("got %q injected, want working", s.Machine.Host())
+	}
+	p.release(s, allocs)
+	if broken.most != 0 {
+		t.Error("the broken service was allocated")
+	}
+
+	// Without another service the command fails
+	r = NewRegistry()
+	r.RegisterServiceInstance(&failingMachine{newCapMachine("broken", 0, 0)})
+	p = r.Services()
+	s = new(Stage)
+	if _, err := p.allocate("Stage.Run", s); err == nil || !strings.Contains(err.Error(), "broken is broken") {
+		t.Errorf("allocate = %v, want the allocation to fail", err)
+	}
+	if st := p.Status(); len(st.Services[0].Allocations) != 0 || s.Machine != nil {
+		t.Errorf("status after the failure %+v, want no allocations", st)
+	}
+}
+
+// A Holder keeps its machine until the build is done
+type Holder struct {
+	BaseArtifact
+	Machine Machine `requirement:"os=linux" inject:"shared"`
+}
+
+func (h *Holder) Run() {}
+
+func TestPoolSharedHolder(t *testing.T) {
+	r := unstampedRegistry()
+	r.Add(new(Holder))
+	stage := stages(r, 1)[0]
+	r.Depends(stage, "Holder.Run")
+	r.RegisterServiceInstance(newCapMachine("only", 1, 0))
+
+	done := make(chan error)
+	go func() { done <- r.newPlan(nil, stage).execute(2) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "shared by Holder.Machine until the build is done") {
+			t.Errorf("execute = %v, want %s to fail", err, stage)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command waits for the shared service")
+	}
+}
+
+func TestPoolInterrupt(t *testing.T) {
+	testWorkspace(t)
+	r := NewRegistry()
+	m := newCapMachine("only", 0, 0)
+	r.RegisterServiceInstance(m)
+	p := r.Services()
+	s := new(Stage)
+	allocs, err := p.allocate("Stage.Run", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := ReadPoolStatus()
+	if err != nil || st == nil || st.Pid != os.Getpid() {
+		t.Fatalf("ReadPoolStatus = %+v, %v, want the allocations of this process", st, err)
+	}
+
+	// The services are deallocated and the services file is removed
+	if err := p.Interrupt(); err != nil {
+		t.Fatal(err)
+	}
+	if m.allocated != 0 {
+		t.Errorf("%d allocations after the interrupt, want none", m.allocated)
+	}
+	if _, err := os.Stat(workspace.GetServicesFilePath()); !os.IsNotExist(err) {
+		t.Errorf("the services file is left after the interrupt: %v", err)
+	}
+	// The command still running releases the services only once
+	p.release(s, allocs)
+	if m.allocated != 0 {
+		t.Errorf("%d allocations after the release, want none", m.allocated)
+	}
+	if _, err := os.Stat(workspace.GetServicesFilePath()); !os.IsNotExist(err) {
+		t.Errorf("the services file is written after the interrupt: %v", err)
+	}
+}
+
+func TestReadPoolStatusStale(t *testing.T) {
+	testWorkspace(t)
+	// A process that is no longer running
+	done := exec.Command(os.Args[0], "-test.run=^$")
+	if err := done.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for pid, want := range map[int]bool{os.Getpid(): true, done.Process.Pid: false} {
+		raw, err := json.Marshal(PoolStatus{Pid: pid, Services: []ServiceStatus{{Service: "only"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(workspace.GetServicesFilePath(), raw, 0666); err != nil {
+			t.Fatal(err)
+		}
+		st, err := ReadPoolStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (st != nil) != want {
+			t.Errorf("ReadPoolStatus of process %d = %+v, want it read %v", pid, st, want)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package artifact
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Properties published by a service, like "os": "linux"
+type Properties map[string]string
+
+func (p Properties) String() string {
+	var res []string
+	for k, v := range p {
+		res = append(res, k+"="+v)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ", ")
+}
+
+// A PropertyService publishes its properties. The requirements of the
+// artifacts are matched against the properties by the framework,
+// instead of passing them to Satisfies.
+type PropertyService interface {
+	Properties() Properties
+}
+
+// A ScoredService declares how good it is. Among the available services
+// matching a requirement, the one with the highest score is chosen.
+// Services that do not declare a score have score 0, and the service
+// registered first wins a tie.
+type ScoredService interface {
+	Score() int
+}
+
+// A Requirement is a parsed requirement expression
+type Requirement struct {
+	text string
+	eval func(Properties) bool
+}
+
+func (r *Requirement) String() string {
+	return r.text
+}
+
+// Matches tells if the properties satisfy the requirement
+func (r *Requirement) Matches(p Properties) bool {
+	return r.eval(p)
+}
+
+// Satisfies parses the requirement and matches it against the
+// properties. Services can implement ServiceAPI.Satisfies with it.
+func (p Properties) Satisfies(requirement string) bool {
+	r, err := ParseRequirement(requirement)
+	return err == nil && r.Matches(p)
+}
+
+// ParseRequirement parses a requirement expression. Comparisons are
+// combined with && (or a comma) and ||, negated with !, and grouped
+// with parentheses. An empty requirement matches all properties.
+//
+//	os=linux, arch=x86_64       os is linux and arch is x86_64
+//	cpus >= 4 && mem > 2048     numbers are compared as numbers
+//	version >= 1.2.0            and versions with two dots as versions
+//	os != windows               also true if os is not published
+//	os in {linux, darwin}       os is one of the values
+//	arch not in {mips, arm}
+//	gpu                         gpu is published
+//	!(os=windows || arch=arm)
+//
+// Values containing other characters than letters, digits and
+// -_.:/+@* are quoted, like name="my machine".
+func ParseRequirement(text string) (*Requirement, error) {
+	p := &reqParser{text: text}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 0 {
+		return &Requirement{text: text, eval: func(Properties) bool { return true }}, nil
+	}
+	eval, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return &Requirement{text: text, eval: eval}, nil
+}
+
+type reqToken struct {
+	text   string
+	quoted bool // a quoted value, never an operator or keyword
+}
+
+type reqParser struct {
+	text   string
+	tokens []reqToken
+	pos    int
+}
+
+func (p *reqParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid requirement %q, %s", p.text, fmt.Sprintf(format, args...))
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.:/+@*", r)
+}
+
+var reqOperators = map[string]bool{
+	"=": true, "==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"&&": true, "||": true, "!": true, ",": true, "(": true, ")": true, "{": true, "}": true,
+}
+
+// lex splits the text into words, quoted values and operators
+func (p *reqParser) lex() error {
+	rs := []rune(p.text)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(rs) && rs[j] != '"'; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				b.WriteRune(rs[j])
+			}
+			if j == len(rs) {
+				return p.errorf("unterminated quote")
+			}
+			p.tokens = append(p.tokens, reqToken{text: b.String(), quoted: true})
+			i = j + 1
+		case isWordRune(r):
+			j := i
+			for j < len(rs) && isWordRune(rs[j]) {
+				j++
+			}
+			p.tokens = append(p.tokens, reqToken{text: string(rs[i:j])})
+			i = j
+		default:
+			op := string(r)
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if !reqOperators[op] {
+				return p.errorf("unexpected %q", op)
+			}
+			p.tokens = append(p.tokens, reqToken{text: op})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+// peek returns the next operator or keyword, or "" at the end
+// and for quoted values
+func (p *reqParser) peek() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+func (p *reqParser) expect(op string) error {
+	if p.peek() != op {
+		if p.pos >= len(p.tokens) {
+			return p.errorf("expected %q at the end", op)
+		}
+		return p.errorf("expected %q before %q", op, p.tokens[p.pos].text)
+	}
+	p.pos++
+	return nil
+}
+
+// or := and { ("||" | "or") and }
+func (p *reqParser) or() (func(Properties) bool, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" || p.peek() == "or" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(props Properties) bool { return l(props) || right(props) }
+	}
+	return left, nil
+}
+
+// and := unary { ("&&" | "and" | ",") unary }, allowing a trailing comma
+func (p *reqParser) and() (func(Properties) bool, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" || p.peek() == "and" || p.peek() == "," {
+		comma := p.peek() == ","
+		p.pos++
+		if comma && (p.pos == len(p.tokens) || p.peek() == ")") {
+			break
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(props Properties) bool { return l(props) && right(props) }
+	}
+	return left, nil
+}
+
+// unary := ("!" | "not") unary | "(" or ")" | comparison
+func (p *reqParser) unary() (func(Properties) bool, error) {
+	switch p.peek() {
+	case "!", "not":
+		p.pos++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(props Properties) bool { return !e(props) }, nil
+	case "(":
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	return p.comparison()
+}
+
+// word returns the next word or quoted value
+func (p *reqParser) word(what string) (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", p.errorf("expected %s at the end", what)
+	}
+	t := p.tokens[p.pos]
+	if !t.quoted && !isWordRune([]rune(t.text)[0]) {
+		return "", p.errorf("expected %s before %q", what, t.text)
+	}
+	p.pos++
+	return t.text, nil
+}
+
+// comparison := key [ op value | ["not"] "in" "{" value {"," value} "}" ]
+func (p *reqParser) comparison() (func(Properties) bool, error) {
+	key, err := p.word("a property")
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		value, err := p.word("a value")
+		if err != nil {
+			return nil, err
+		}
+		return func(props Properties) bool {
+			v, ok := props[key]
+			if !ok {
+				return op == "!="
+			}
+			c := compareValues(v, value)
+			switch op {
+			case "!=":
+				return c != 0
+			case "<":
+				return c < 0
+			case "<=":
+				return c <= 0
+			case ">":
+				return c > 0
+			case ">=":
+				return c >= 0
+			}
+			return c == 0
+		}, nil
+	case "in", "not":
+		negate := op == "not"
+		p.pos++
+		if negate {
+			if err := p.expect("in"); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+		var set []string
+		for {
+			value, err := p.word("a value")
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, value)
+			if p.peek() != "," {
+				break
+			}
+			p.pos++
+		}
+		if err := p.expect("}"); err != nil {
+			return nil, err
+		}
+		return func(props Properties) bool {
+			v, ok := props[key]
+			found := false
+			for _, s := range set {
+				found = found || ok && compareValues(v, s) == 0
+			}
+			return found != negate
+		}, nil
+	}
+	return func(props Properties) bool {
+		_, ok := props[key]
+		return ok
+	}, nil
+}
+
+// compareValues compares the values as versions, like 1.10.0 and
+// 1.9, if one of them has two dots or a prerelease, or as numbers if
+// both values are, like 0.8 and 0.75, and else as strings
+func compareValues(a, b string) int {
+	if av, err := ParseVersion(a); err == nil && av.Git == "" {
+		if bv, err := ParseVersion(b); err == nil && bv.Git == "" && (isVersion(a, av) || isVersion(b, bv)) {
+			return av.Compare(bv)
+		}
+	}
+	if af, err := strconv.ParseFloat(a, 64); err == nil {
+		if bf, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case af < bf:
+				return -1
+			case af > bf:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// isVersion tells if the value is a version rather than a decimal
+// number, like 1.2.0 or 1.2-rc.1, and not 1.2
+func isVersion(s string, v Version) bool {
+	return v.Prerelease != "" || v.Build != "" || strings.Count(s, ".") >= 2
+}
@@ -0,0 +1,132 @@
+package artifact
+
+import (
+	"testing"
+)
+
+func TestRequirement(t *testing.T) {
+	props := Properties{"os": "linux", "arch": "x86_64", "cpus": "8", "mem": "16384",
+		"version": "1.10.0", "load": "0.8", "name": "my machine", "gpu": ""}
+	tests := []struct {
+		requirement string
+		want        bool
+	}{
+		{"", true},
+		{"os=linux", true},
+		{"os==linux", true},
+		{"os=windows", false},
+		{"os=linux, arch=x86_64", true},
+		{"os=linux, arch=arm", false},
+		{"os=linux,", true},
+		{"(os=linux, arch=x86_64,)", true},
+		{"os=linux && arch=x86_64", true},
+		{"os=linux and arch=arm", false},
+		{"os=windows || arch=x86_64", true},
+		{"os=windows or arch=arm", false},
+		// Numbers are compared as numbers
+		{"cpus >= 4", true},
+		{"cpus > 10", false},
+		{"mem > 2048", true},
+		{"cpus < 10", true},
+		{"load > 0.75", true},
+		{"load < 0.10", false},
+		// Versions with two dots are compared as versions, 1.10.0 is above 1.9
+		{"version >= 1.9", true},
+		{"version > 1.9.2", true},
+		{"version < 1.9", false},
+		{"version = 1.10", true},
+		// Other values are compared as strings
+		{"os < mac", true},
+		{"os > mac", false},
+		// A property that is not published only satisfies !=
+		{"disk > 10", false},
+		{"disk = 10", false},
+		{"disk != 10", true},
+		{"os != windows", true},
+		{"os != linux", false},
+		// Sets
+		{"os in {linux, darwin}", true},
+		{"os in {darwin, windows}", false},
+		{"os not in {darwin, windows}", true},
+		{"arch not in {x86_64}", false},
+		{"disk in {ssd}", false},
+		{"disk not in {ssd}", true},
+		{"cpus in {4, 8.0}", true},
+		// Published properties
+		{"gpu", true},
+		{"disk", false},
+		{"!disk", true},
+		{"not gpu", false},
+		{"!(os=windows || arch=arm)", true},
+		{"!(os=linux || arch=arm)", false},
+		// Quoted values
+		{`name="my machine"`, true},
+		{`name = "my \"machine\""`, false},
+		{`os="linux"`, true},
+		{`os in {"linux", "darwin"}`, true},
+	}
+	for _, tt := range tests {
+		r, err := ParseRequirement(tt.requirement)
+		if err != nil {
+			t.Errorf("ParseRequirement(%q): %v", tt.requirement, err)
+			continue
+		}
+		if got := r.Matches(props); got != tt.want {
+			t.Errorf("%q matches %v = %v, want %v", tt.requirement, props, got, tt.want)
+		}
+	}
+}
+
+func TestParseRequirementErrors(t *testing.T) {
+	for _, text := range []string{
+		"os=",
+		"=linux",
+		"os=linux &&",
+		"os=linux,,arch=x86_64",
+		"(os=linux",
+		"os=linux)",
+		"os in linux",
+		"os in {linux",
+		"os in {}",
+		"os not {linux}",
+		`name="my machine`,
+		"os=linux; arch=x86_64",
+		"os linux",
+	} {
+		if _, err := ParseRequirement(text); err == nil {
+			t.Errorf("ParseRequirement(%q) did not fail", text)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		// Versions, if one value has two dots or a prerelease
+		{"1.10.0", "1.9", 1},
+		{"1.9", "1.10.0", -1},
+		{"1.9.2", "1.10", -1},
+		{"2", "2.0.0", 0},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0", "1.0-rc.1", 1},
+		// Decimal numbers
+		{"0.8", "0.75", 1},
+		{"2.5", "2.25", 1},
+		{"1.10", "1.9", -1},
+		{"1.5", "1.50", 0},
+		{"10", "9", 1},
+		{"2.5e3", "300", 1},
+		{"-1", "0", -1},
+		{"abc", "abd", -1},
+		{"linux", "linux", 0},
+		// A version and a string are compared as strings
+		{"1.10", "beta", -1},
+	}
+	for _, tt := range tests {
+		if got := compareValues(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareValues(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package artifact
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// Artifact names in commands may carry a version constraint after
+// an @, like "gcc@^1.2.Build" or "gcc@git:abc123.Build". Without
+// a constraint, the version pinned for the name in the workspace
+// config file is used. Among the artifacts matching the name and the
+// constraint, the one with the highest version is selected.
+
+// A bound is one comparison of a constraint, like >=1.2.0
+type bound struct {
+	op string // one of = > >= < <=
+	v  Version
+}
+
+func (b bound) matches(v Version) bool {
+	c := v.Compare(b.v)
+	switch b.op {
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return c == 0
+}
+
+// A Constraint selects versions, like ">=1.2 <2", "^1.2.3 || ~0.9",
+// "1.x" or "git:abc123"
+type Constraint struct {
+	text string
+	git  string
+	alts [][]bound // any of the alternatives, with all its bounds
+}
+
+func (c *Constraint) String() string {
+	return c.text
+}
+
+var rangeRegExp = regexp.MustCompile(`^(\^|~|>=|<=|==|=|>|<)?\s*[vV]?([0-9]+|[xX*])(?:\.([0-9]+|[xX*]))?(?:\.([0-9]+|[xX*]))?` +
+	`(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-.]+)?$`)
+
+// ParseConstraint parses a version constraint. Alternatives are
+// separated by ||, and the comparisons of an alternative by commas
+// or spaces. The comparisons are
+//
+//	1.2.3, =1.2.3   exactly 1.2.3
+//	1.2, 1.2.x      any 1.2 version
+//	>1.2 >=1.2 <2 <=2.1
+//	^1.2.3          compatible versions, >=1.2.3 <2.0.0
+//	~1.2.3          patch versions, >=1.2.3 <1.3.0
+//	1.2 - 1.4       inclusive range, >=1.2.0 <1.5.0
+//	git:abc123      git commits starting with abc123
+//
+// Prereleases only match comparisons naming a prerelease of the
+// same major, minor and micro version, like >=1.2.3-rc.1.
+func ParseConstraint(text string) (*Constraint, error) {
+	c := &Constraint{text: text}
+	if strings.HasPrefix(text, "git:") {
+		c.git = strings.ToLower(strings.TrimPrefix(text, "git:"))
+		if c.git == "" {
+			return nil, fmt.Errorf("invalid version constraint %q, missing git hash", text)
+		}
+		return c, nil
+	}
+	for _, alt := range strings.Split(text, "||") {
+		tokens := strings.Fields(strings.Replace(alt, ",", " ", -1))
+		var bounds []bound
+		for i := 0; i < len(tokens); i++ {
+			tok := tokens[i]
+			// An operator separated from its version, like ">= 1.2"
+			if rangeRegExp.FindStringSubmatch(tok) == nil && i+1 < len(tokens) {
+				if rangeRegExp.MatchString(tok + tokens[i+1]) {
+					tok += tokens[i+1]
+					i++
+				}
+			}
+			// A hyphen range, like "1.2 - 1.4"
+			if i+2 < len(tokens) && tokens[i+1] == "-" {
+				lo, err := comparison(">=", tok, text)
+				if err != nil {
+					return nil, err
+				}
+				hi, err := comparison("<=", tokens[i+2], text)
+				if err != nil {
+					return nil, err
+				}
+				bounds = append(append(bounds, lo...), hi...)
+				i += 2
+				continue
+			}
+			b, err := comparison("", tok, text)
+			if err != nil {
+				return nil, err
+			}
+			bounds = append(bounds, b...)
+		}
+		if len(bounds) == 0 && strings.TrimSpace(alt) == "" {
+			return nil, fmt.Errorf("invalid version constraint %q, empty alternative", text)
+		}
+		c.alts = append(c.alts, bounds)
+	}
+	return c, nil
+}
+
+// comparison turns one comparison into bounds. op is used if
+// the comparison has no operator of its own.
+func comparison(op string, tok string, text string) ([]bound, error) {
+	m := rangeRegExp.FindStringSubmatch(tok)
+	if m == nil {
+		return nil, fmt.Errorf("invalid version constraint %q, unexpected %q", text, tok)
+	}
+	if m[1] != "" {
+		op = m[1]
+	}
+	// Only the parts before the first wildcard are given
+	var nums []int
+	for _, p := range m[2:5] {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+	}
+	given := len(nums)
+	for len(nums) < 3 {
+		nums = append(nums, 0)
+	}
+	v := Version{Major: nums[0], Minor: nums[1], Micro: nums[2]}
+	if given == 3 {
+		v.Prerelease = m[5]
+	}
+	// next is the lowest version above all versions
+	// starting with the first n given parts
+	next := func(n int) Version {
+		switch n {
+		case 1:
+			return Version{Major: v.Major + 1}
+		case 2:
+			return Version{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return Version{Major: v.Major, Minor: v.Minor, Micro: v.Micro + 1}
+	}
+	switch op {
+	case "", "=", "==":
+		if given == 0 {
+			return nil, nil
+		}
+		if given == 3 {
+			return []bound{{"=", v}}, nil
+		}
+		return []bound{{">=", v}, {"<", next(given)}}, nil
+	case "^":
+		if given == 0 {
+			return nil, nil
+		}
+		// The first non zero part may not change
+		n := given
+		for i, p := range nums[:given] {
+			if p != 0 {
+				n = i + 1
+				break
+			}
+		}
+		return []bound{{">=", v}, {"<", next(n)}}, nil
+	case "~":
+		if given == 0 {
+			return nil, nil
+		}
+		n := 2
+		if given == 1 {
+			n = 1
+		}
+		return []bound{{">=", v}, {"<", next(n)}}, nil
+	case ">":
+		if given < 3 {
+			return []bound{{">=", next(given)}}, nil
+		}
+	case "<=":
+		if given < 3 {
+			return []bound{{"<", next(given)}}, nil
+		}
+	}
+	if given == 0 {
+		return nil, fmt.Errorf("invalid version constraint %q, %s needs a version", text, op)
+	}
+	return []bound{{op, v}}, nil
+}
+
+// Matches tells if the version satisfies the constraint
+func (c *Constraint) Matches(v Version) bool {
+	if c.git != "" {
+		return v.Git != "" && strings.HasPrefix(strings.ToLower(v.Git), c.git)
+	}
+	if v.Git != "" {
+		return false
+	}
+	for _, alt := range c.alts {
+		if matchesAll(alt, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(bounds []bound, v Version) bool {
+	allowPrerelease := v.Prerelease == ""
+	for _, b := range bounds {
+		if !b.matches(v) {
+			return false
+		}
+		if b.v.Prerelease != "" && b.v.Major == v.Major && b.v.Minor == v.Minor && b.v.Micro == v.Micro {
+			allowPrerelease = true
+		}
+	}
+	return allowPrerelease
+}
+
+// splitName splits an artifact name into the name and the
+// version constraint, "gcc@>=1.2" into "gcc" and ">=1.2"
+func splitName(name string) (string, string) {
+	if i := strings.Index(name, "@"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// Resolve returns the artifact selected by the name, which may carry
+// a version constraint, like "gcc@^1.2". Without a constraint, the
+// version pinned for the name in the workspace config file applies.
+// The artifact with the highest version satisfying the constraint is
+// returned, preferring stable versions to prereleases and git commits.
+// A registered ID, like "Gcc" or "Gcc@=1.2.0", selects that artifact.
+// Names are not case sensitive. If several artifacts have the same
+// version, or IDs only differing in case from the name, the name is
+// ambiguous and an error is returned. A pin selecting another artifact
+// than the one with the name as its ID is an error too.
+func (r *Registry) Resolve(name string) (Artifact, error) {
+	base, text := splitName(name)
+	pinned := false
+	if text == "" {
+		text, pinned = workspace.PinnedVersion(base)
+	}
+	same := r.lookupFold(name)
+	if !pinned {
+		switch len(same) {
+		case 0:
+		case 1:
+			return same[0], nil
+		default:
+			return nil, r.ambiguous(name, same)
+		}
+	}
+	var c *Constraint
+	if text != "" {
+		var err error
+		if c, err = ParseConstraint(text); err != nil {
+			return nil, err
+		}
+	}
+	found := r.Find(base)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("artifact %q not found", base)
+	}
+	if matching := r.FindVersion(base, c); len(matching) > 0 {
+		// The highest versions come first
+		n := 1
+		for n < len(matching) && GetVersion(matching[n]).Compare(GetVersion(matching[0])) == 0 {
+			n++
+		}
+		if n > 1 {
+			return nil, r.ambiguous(name, matching[:n])
+		}
+		if pinned && len(same) > 0 && !containsArtifact(same, matching[0]) {
+			return nil, fmt.Errorf("artifact name %q is the ID of %s, but the workspace pins it to %q, selecting %s",
+				name, strings.Join(ids(r, same), ", "), text, r.ID(matching[0]))
+		}
+		return matching[0], nil
+	}
+	var available []Version
+	for _, a := range found {
+		available = append(available, GetVersion(a))
+	}
+	return nil, &VersionError{Name: base, Constraint: text, Pinned: pinned, Available: available}
+}
+
+// lookupFold returns the artifacts registered with the ID, without
+// regard to case unless an artifact has exactly the ID
+func (r *Registry) lookupFold(id string) []Artifact {
+	if a, ok := r.Lookup(id); ok {
+		return []Artifact{a}
+	}
+	var res []Artifact
+	for _, e := range r.entries {
+		if strings.EqualFold(e.id, id) {
+			res = append(res, e.a)
+		}
+	}
+	return res
+}
+
+func (r *Registry) ambiguous(name string, arties []Artifact) error {
+	return fmt.Errorf("artifact name %q is ambiguous, it matches %s", name, strings.Join(ids(r, arties), ", "))
+}
+
+// ids returns the IDs of the artifacts
+func ids(r *Registry, arties []Artifact) []string {
+	var res []string
+	for _, a := range arties {
+		res = append(res, r.ID(a))
+	}
+	return res
+}
+
+func containsArtifact(arties []Artifact, a Artifact) bool {
+	for _, o := range arties {
+		if o == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the artifact of the default registry selected
+// by the name, see Registry.Resolve
+func Resolve(name string) (Artifact, error) {
+	return Default.Resolve(name)
+}
+
+// higher tells if v is preferred to o when resolving a name
+func higher(v, o Version) bool {
+	if v.Stable() != o.Stable() {
+		return v.Stable()
+	}
+	return v.Compare(o) > 0
+}
+
+// splitCommand splits a command into the artifact name and
+// the command name, on the last dot
+func splitCommand(cmd string) (string, string, error) {
+	i := strings.LastIndex(cmd, ".")
+	if i <= 0 || i == len(cmd)-1 {
+		return "", "", fmt.Errorf("invalid command %q, expected artifact.command", cmd)
+	}
+	return cmd[:i], cmd[i+1:], nil
+}
+
+// canonical returns the command with the name of the artifact
+// it resolves to, like "GccV2.Build" for "gcc.Build". Commands
+// that do not resolve are returned as they are.
+func (r *Registry) canonical(cmd string) string {
+	a, meth, err := r.lookup(cmd)
+	if err != nil {
+		return cmd
+	}
+	return r.ID(a) + "." + meth
+}
+
+// sameCommand returns the names among the declared ones that
+// resolve to the canonical command cmd
+func (r *Registry) sameCommand(cmd string, declared []string) []string {
+	var res []string
+	for _, d := range declared {
+		if d == cmd || r.canonical(d) == cmd {
+			res = append(res, d)
+		}
+	}
+	return res
+}
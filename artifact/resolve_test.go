@@ -0,0 +1,201 @@
+package artifact
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/staffano/crazy-build/workspace"
+)
+
+func TestResolve(t *testing.T) {
+	r := testRegistry()
+	tests := []struct {
+		name string
+		want string // the ID, or "" if an error is expected
+	}{
+		// A registered ID selects the artifact, without regard to case
+		{"Gcc", "Gcc"},
+		{"gcc", "Gcc"},
+		{"GCC", "Gcc"},
+		{"gccv1", "GccV1"},
+		{"GccV2", "GCCV2"},
+		{"Clang@=9.0.1", "Clang@=9.0.1"},
+		{"clang@=9.0.1", "Clang@=9.0.1"},
+		// Else the highest version satisfying the constraint
+		{"gcc@^2", "GccV2v1v3"},
+		{"GCC@^2", "GccV2v1v3"},
+		{"gcc@2.0", "GCCV2"},
+		{"gcc@<2", "GccV1"},
+		{"gcc@git:abc", "GccVgitVabc123V"},
+		// Stable versions are preferred to prereleases
+		{"clang", "Clang@=9.0.1"},
+		{"CLANG", "Clang@=9.0.1"},
+		{"clang@>=10.0.0-rc.1", "Clang@=10.0.0-rc.1"},
+		{"gcc@>=3", ""},
+		{"gcc@^", ""},
+		{"rust", ""},
+	}
+	for _, tt := range tests {
+		a, err := r.Resolve(tt.name)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("Resolve(%q) = %s, want an error", tt.name, r.ID(a))
+		case tt.want != "" && err != nil:
+			t.Errorf("Resolve(%q): %v", tt.name, err)
+		case tt.want != "" && r.ID(a) != tt.want:
+			t.Errorf("Resolve(%q) = %s, want %s", tt.name, r.ID(a), tt.want)
+		}
+	}
+}
+
+type Make struct{ BaseArtifact }
+type MAKE struct{ BaseArtifact }
+type LdV1 struct{ BaseArtifact }
+type LdV1v0 struct{ BaseArtifact }
+type LdV2 struct{ BaseArtifact }
+
+func (m *Make) Build()  {}
+func (l *LdV1) Link()   {}
+func (l *LdV1v0) Link() {}
+func (l *LdV2) Link()   {}
+
+func TestResolveAmbiguous(t *testing.T) {
+	r := NewRegistry()
+	r.Add(new(Make), new(MAKE), new(LdV1), new(LdV1v0), new(LdV2))
+	tests := []struct {
+		name string
+		want string // the ID, or the ambiguous IDs
+	}{
+		{"Make", "Make"},
+		{"MAKE", "MAKE"},
+		{"make", "Make, MAKE"},
+		{"ld", "LdV2"},
+		{"ld@1", "LdV1, LdV1v0"},
+		{"ld@1.x", "LdV1, LdV1v0"},
+		{"LdV1v0", "LdV1v0"},
+	}
+	for _, tt := range tests {
+		a, err := r.Resolve(tt.name)
+		if strings.Contains(tt.want, ",") {
+			if err == nil || !strings.Contains(err.Error(), "ambiguous, it matches "+tt.want) {
+				t.Errorf("Resolve(%q) = %v, %v, want it to be ambiguous among %s", tt.name, a, err, tt.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.name, err)
+		} else if r.ID(a) != tt.want {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.name, r.ID(a), tt.want)
+		}
+	}
+
+	r.Depends("Make.Build", "ld@1.Link", "LdV2.Link")
+	err := r.Validate()
+	if err == nil || !strings.Contains(err.Error(), `ld@1.Link: artifact name "ld@1" is ambiguous`) {
+		t.Errorf("Validate = %v, want ld@1 to be ambiguous", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "LdV2") {
+		t.Errorf("Validate = %v, want LdV2.Link to be valid", err)
+	}
+}
+
+// pinVersions loads a workspace config file pinning the versions
+func pinVersions(t *testing.T, versions string) {
+	dir := testWorkspace(t)
+	config := filepath.Join(dir, workspace.WspConfigFolder, workspace.ConfigFile)
+	if err := os.WriteFile(config, []byte(`{"versions": `+versions+`}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		workspace.UnsetVar("WORKSPACE", false)
+		workspace.Configuration().Versions = nil
+	})
+}
+
+func TestResolvePinned(t *testing.T) {
+	pinVersions(t, `{"gcc": "^2", "clang": ">=10.0.0-rc.1", "gccv1": "^1"}`)
+	r := testRegistry()
+	tests := []struct {
+		name string
+		want string // the ID, or the error
+	}{
+		{"gcc@<2", "GccV1"},
+		{"clang", "Clang@=10.0.0-rc.1"},
+		{"Clang@=9.0.1", "Clang@=9.0.1"},
+		{"gccv1", "GccV1"},
+		// The pin selects another artifact than the ID
+		{"gcc", `artifact name "gcc" is the ID of Gcc, but the workspace pins it to "^2", selecting GccV2v1v3`},
+		{"Gcc", `artifact name "Gcc" is the ID of Gcc, but the workspace pins it to "^2"`},
+	}
+	for _, tt := range tests {
+		a, err := r.Resolve(tt.name)
+		if strings.HasPrefix(tt.want, "artifact name") {
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Resolve(%q) = %v, %v, want %q", tt.name, a, err, tt.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.name, err)
+		} else if r.ID(a) != tt.want {
+			t.Errorf("Resolve(%q) = %s, want %s", tt.name, r.ID(a), tt.want)
+		}
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{"1.2.3", []string{"1.2.3", "1.2.3+linux"}, []string{"1.2.4", "1.2.3-rc.1", "git:abc"}},
+		{"=1.2.3", []string{"1.2.3"}, []string{"1.2.2"}},
+		{"1.2", []string{"1.2.0", "1.2.9"}, []string{"1.1.9", "1.3.0", "1.2.5-rc.1"}},
+		{"1.x", []string{"1.0.0", "1.9.9"}, []string{"0.9.9", "2.0.0"}},
+		{"*", []string{"0.0.1", "5.0.0"}, []string{"1.0.0-rc.1", "git:abc"}},
+		{">=1.2 <2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0", "2.0.0-rc.1"}},
+		{">= 1.2, < 2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{">1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{"<=2.1", []string{"2.1.9"}, []string{"2.2.0"}},
+		{"<1.2.3", []string{"1.2.2"}, []string{"1.2.3", "1.2.3-rc.1"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0"}},
+		{"~1", []string{"1.0.0", "1.5.0"}, []string{"2.0.0"}},
+		{"1.2 - 1.4", []string{"1.2.0", "1.4.9"}, []string{"1.1.9", "1.5.0"}},
+		{"^1.2 || ~0.9", []string{"1.5.0", "0.9.1"}, []string{"0.8.0", "2.0.0"}},
+		// Prereleases only match bounds naming a prerelease of the same version
+		{">=1.2.3-rc.1", []string{"1.2.3-rc.1", "1.2.3-rc.2", "1.2.3", "1.3.0"}, []string{"1.2.3-beta", "1.3.0-rc.1"}},
+		{"git:ABC", []string{"git:abc123", "git:ABCdef"}, []string{"git:def", "1.0.0"}},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", tt.constraint, err)
+			continue
+		}
+		for _, v := range tt.matches {
+			if !c.Matches(MustParseVersion(v)) {
+				t.Errorf("%q does not match %s", tt.constraint, v)
+			}
+		}
+		for _, v := range tt.rejects {
+			if c.Matches(MustParseVersion(v)) {
+				t.Errorf("%q matches %s", tt.constraint, v)
+			}
+		}
+	}
+	for _, text := range []string{"git:", ">=", "^", "1.2 ||", "latest", "1.2.3.4", ">=1.2 <"} {
+		if _, err := ParseConstraint(text); err == nil {
+			t.Errorf("ParseConstraint(%q) did not fail", text)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package artifact
+
+// A node is a command in the execution graph
+type node struct {
+	cmd        string
+	args       []string
+	sig        string // signature, set when the node is executed
+	deps       []*node
+	dependents []*node
+	pending    int   // number of dependencies not yet executed
+	err        error // set if the command failed or was not executed
+}
+
+// A plan is the graph of all commands needed to execute
+// a set of target commands
+type plan struct {
+	r     *Registry
+	nodes map[string]*node
+	order []*node // dependencies always come before their dependents
+}
+
+// newPlan builds the command graph for the targets from the
+// declared dependencies, which must be free of cycles. The
+// targets are called with args.
+func (r *Registry) newPlan(args []string, targets ...string) *plan {
+	p := &plan{r: r, nodes: make(map[string]*node)}
+	for _, t := range targets {
+		p.add(t).args = args
+	}
+	return p
+}
+
+// add the command, and recursively its dependencies, to the plan
+func (p *plan) add(cmd string) *node {
+	cmd = p.r.canonical(cmd)
+	if n, ok := p.nodes[cmd]; ok {
+		return n
+	}
+	n := &node{cmd: cmd}
+	p.nodes[cmd] = n
+	for _, dep := range p.r.edges(cmd) {
+		dn := p.add(dep.cmd)
+		if n.dependsOn(dn) {
+			continue
+		}
+		n.deps = append(n.deps, dn)
+		dn.dependents = append(dn.dependents, n)
+	}
+	p.order = append(p.order, n)
+	return n
+}
+
+func (n *node) dependsOn(d *node) bool {
+	for _, dn := range n.deps {
+		if dn == d {
+			return true
+		}
+	}
+	return false
+}
+
+// failedDependency returns a dependency of the node that
+// failed or was not executed, if any
+func (n *node) failedDependency() *node {
+	for _, d := range n.deps {
+		if d.err != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// execute runs all commands in the plan. A command is started as soon
+// as all its dependencies are finished, with at most jobs commands
+// running at the same time. Commands depending on a failed command are
+// not executed.
+func (p *plan) execute(jobs int) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	ready := make(chan *node, len(p.order))
+	finished := make(chan *node)
+	for _, n := range p.order {
+		n.pending = len(n.deps)
+		if n.pending == 0 {
+			ready <- n
+		}
+	}
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for n := range ready {
+				if d := n.failedDependency(); d != nil {
+					n.err = &DependencyError{Cmd: n.cmd, Dependency: d.cmd}
+				} else if err := p.r.run(n); err != nil {
+					n.err = &CommandError{Cmd: n.cmd, Err: err}
+				}
+				finished <- n
+			}
+		}()
+	}
+	for remaining := len(p.order); remaining > 0; remaining-- {
+		n := <-finished
+		for _, d := range n.dependents {
+			d.pending--
+			if d.pending == 0 {
+				ready <- d
+			}
+		}
+	}
+	close(ready)
+
+	var res BuildError
+	for _, n := range p.order {
+		if n.err != nil {
+			res.Errors = append(res.Errors, n.err)
+		}
+	}
+	if len(res.Errors) > 0 {
+		return &res
+	}
+	return nil
+}
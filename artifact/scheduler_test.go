@@ -0,0 +1,156 @@
+package artifact
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A recorder records the commands executed, and how many
+// of them ran at the same time
+type recorder struct {
+	mu      sync.Mutex
+	order   []string
+	running int
+	most    int
+}
+
+func (rec *recorder) run(cmd string) {
+	rec.mu.Lock()
+	rec.order = append(rec.order, cmd)
+	rec.running++
+	if rec.running > rec.most {
+		rec.most = rec.running
+	}
+	rec.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	rec.mu.Lock()
+	rec.running--
+	rec.mu.Unlock()
+}
+
+func (rec *recorder) index(cmd string) int {
+	for i, c := range rec.order {
+		if c == cmd {
+			return i
+		}
+	}
+	return -1
+}
+
+type Steps struct {
+	BaseArtifact
+	rec *recorder
+}
+
+func (s *Steps) First() { s.rec.run("Steps.First") }
+func (s *Steps) Left()  { s.rec.run("Steps.Left") }
+func (s *Steps) Right() { s.rec.run("Steps.Right") }
+func (s *Steps) Last()  { s.rec.run("Steps.Last") }
+func (s *Steps) Broken() error {
+	s.rec.run("Steps.Broken")
+	return errors.New("broken")
+}
+
+type Batch struct {
+	BaseArtifact
+	rec *recorder
+}
+
+func (b *Batch) One()   { b.rec.run("Batch.One") }
+func (b *Batch) Two()   { b.rec.run("Batch.Two") }
+func (b *Batch) Three() { b.rec.run("Batch.Three") }
+func (b *Batch) Four()  { b.rec.run("Batch.Four") }
+
+// unstampedRegistry returns a registry executing the commands
+// without reading or writing stamps
+func unstampedRegistry() *Registry {
+	r := NewRegistry()
+	r.IgnoreStamps = true
+	return r
+}
+
+func TestExecuteOrder(t *testing.T) {
+	rec := new(recorder)
+	r := unstampedRegistry()
+	r.Add(&Steps{rec: rec})
+	r.Depends("Steps.Left", "Steps.First")
+	r.Depends("Steps.Right", "Steps.First")
+	r.Depends("Steps.Last", "Steps.Left", "Steps.Right")
+	if err := r.newPlan(nil, "Steps.Last").execute(4); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.order) != 4 {
+		t.Fatalf("executed %v, want 4 commands", rec.order)
+	}
+	tests := []struct{ before, after string }{
+		{"Steps.First", "Steps.Left"},
+		{"Steps.First", "Steps.Right"},
+		{"Steps.Left", "Steps.Last"},
+		{"Steps.Right", "Steps.Last"},
+	}
+	for _, tt := range tests {
+		if rec.index(tt.before) > rec.index(tt.after) {
+			t.Errorf("%s executed after %s in %v", tt.before, tt.after, rec.order)
+		}
+	}
+	// Left and Right only depend on First, so they run at the same time
+	if rec.most != 2 {
+		t.Errorf("at most %d commands ran at the same time, want 2", rec.most)
+	}
+}
+
+func TestExecuteJobs(t *testing.T) {
+	targets := []string{"Batch.One", "Batch.Two", "Batch.Three", "Batch.Four"}
+	for _, jobs := range []int{0, 1, 2, 4} {
+		rec := new(recorder)
+		r := unstampedRegistry()
+		r.Add(&Batch{rec: rec})
+		if err := r.newPlan(nil, targets...).execute(jobs); err != nil {
+			t.Fatal(err)
+		}
+		want := jobs
+		if want < 1 {
+			want = 1
+		}
+		if len(rec.order) != 4 {
+			t.Errorf("with %d jobs executed %v, want 4 commands", jobs, rec.order)
+		}
+		if rec.most != want {
+			t.Errorf("with %d jobs at most %d commands ran at the same time, want %d", jobs, rec.most, want)
+		}
+	}
+}
+
+func TestExecuteFailure(t *testing.T) {
+	rec := new(recorder)
+	r := unstampedRegistry()
+	r.Add(&Steps{rec: rec})
+	r.Depends("Steps.Left", "Steps.Broken")
+	r.Depends("Steps.Last", "Steps.Left")
+	err := r.newPlan(nil, "Steps.Last", "Steps.Right").execute(2)
+	var be *BuildError
+	if !errors.As(err, &be) {
+		t.Fatalf("execute returned %v, want a *BuildError", err)
+	}
+	var failed []string
+	for _, e := range be.Errors {
+		switch e := e.(type) {
+		case *CommandError:
+			failed = append(failed, "failed "+e.Cmd)
+		case *DependencyError:
+			failed = append(failed, "skipped "+e.Cmd)
+		}
+	}
+	want := []string{"failed Steps.Broken", "skipped Steps.Left", "skipped Steps.Last"}
+	if !reflect.DeepEqual(failed, want) {
+		t.Errorf("errors %v, want %v", failed, want)
+	}
+	// The command that does not depend on the failed one is executed
+	if !reflect.DeepEqual(rec.order, []string{"Steps.Broken", "Steps.Right"}) &&
+		!reflect.DeepEqual(rec.order, []string{"Steps.Right", "Steps.Broken"}) {
+		t.Errorf("executed %v, want Steps.Broken and Steps.Right", rec.order)
+	}
+}
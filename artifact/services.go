@@ -1,5 +1,11 @@
 package artifact
 
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
 // ServiceAPI is the API all services have to comply to
 // in order to be handled as services
 type ServiceAPI interface {
@@ -18,11 +24,223 @@ type ServiceAPI interface {
 	Satisfies(requirement string) bool
 }
 
-// services are all registered services and they all implement
-// a specific API interface that is used to find them
-var services []*ServiceAPI
+// RegisterServiceInstance registers a service instance in the service pool.
+// All services implement a specific API interface that is used to find them.
+// A CapacityService can be allocated as many times as its capacity at once.
+func (r *Registry) RegisterServiceInstance(si ServiceAPI) {
+	r.pool.register("", si)
+}
 
-// RegisterServiceInstance registers a service instance in the service database
+// RegisterServiceInstance registers a service instance in the default registry
 func RegisterServiceInstance(si ServiceAPI) {
-	services = append(services, &si)
+	Default.RegisterServiceInstance(si)
+}
+
+// RegisterNamedServiceInstance registers a service instance with a name,
+// like "docker-big", that artifacts request with the service tag
+func (r *Registry) RegisterNamedServiceInstance(name string, si ServiceAPI) {
+	r.pool.register(name, si)
+}
+
+// RegisterNamedServiceInstance registers a named service instance
+// in the default registry
+func RegisterNamedServiceInstance(name string, si ServiceAPI) {
+	Default.RegisterNamedServiceInstance(name, si)
+}
+
+// A ServiceRequirement is a field of an artifact that gets a service
+// injected. The field is tagged with the requirement the service has to
+// satisfy, the name of the service, or both:
+//
+//	Machine DockerAPI `requirement:"cpus >= 4"`
+//	Big     DockerAPI `service:"docker-big" inject:"shared" command:"Build,Test"`
+//
+// By default the field gets a service allocated for each command, and
+// only while the command runs. The inject tag takes the modifiers
+//
+//	optional  the field is left nil, instead of failing the command,
+//	          if no registered service satisfies the requirement
+//	shared    the service is allocated once and used by all commands
+//	          of the artifact, until the build is done
+//
+// The command tag limits the injection to the listed commands.
+type ServiceRequirement struct {
+	Field       string
+	Type        reflect.Type // the service interface
+	Requirement string
+	Name        string   // the name or ID of the service, or ""
+	Optional    bool     // set by inject:"optional"
+	Shared      bool     // set by inject:"shared"
+	Commands    []string // the commands getting the service, all if empty
+}
+
+// injectModifiers are the modifiers of the inject tag
+var injectModifiers = map[string]bool{"optional": true, "shared": true}
+
+// splitTag splits a comma separated tag value
+func splitTag(tag string) []string {
+	var res []string
+	for _, v := range strings.Split(tag, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// ServiceRequirements returns the services required by the artifact,
+// which are the fields with a requirement or service tag
+func ServiceRequirements(a Artifact) []ServiceRequirement {
+	var res []ServiceRequirement
+	t := reflect.Indirect(reflect.ValueOf(a)).Type()
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		req, hasReq := field.Tag.Lookup("requirement")
+		name, hasName := field.Tag.Lookup("service")
+		if !hasReq && !hasName {
+			continue
+		}
+		sr := ServiceRequirement{Field: field.Name, Type: field.Type, Requirement: req, Name: name}
+		for _, m := range splitTag(field.Tag.Get("inject")) {
+			sr.Optional = sr.Optional || m == "optional"
+			sr.Shared = sr.Shared || m == "shared"
+		}
+		sr.Commands = splitTag(field.Tag.Get("command"))
+		res = append(res, sr)
+	}
+	return res
+}
+
+// commandRequirements returns the services required by the command
+// meth of the artifact
+func commandRequirements(a Artifact, meth string) []ServiceRequirement {
+	var res []ServiceRequirement
+	for _, req := range ServiceRequirements(a) {
+		if req.forCommand(meth) {
+			res = append(res, req)
+		}
+	}
+	return res
+}
+
+// forCommand tells if the command meth gets the service injected
+func (req *ServiceRequirement) forCommand(meth string) bool {
+	if len(req.Commands) == 0 {
+		return true
+	}
+	for _, c := range req.Commands {
+		if c == meth {
+			return true
+		}
+	}
+	return false
+}
+
+// checkServiceTags returns the problems with the inject and
+// command tags of the artifact
+func checkServiceTags(a Artifact) []string {
+	var problems []string
+	t := reflect.Indirect(reflect.ValueOf(a)).Type()
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	commands := make(map[string]bool)
+	for _, c := range GetCommands(a) {
+		commands[c] = true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		_, hasReq := field.Tag.Lookup("requirement")
+		_, hasName := field.Tag.Lookup("service")
+		for _, tag := range []string{"inject", "command"} {
+			if _, ok := field.Tag.Lookup(tag); ok && !hasReq && !hasName {
+				problems = append(problems, fmt.Sprintf("%s.%s: %s tag without a requirement or service tag", Name(a), field.Name, tag))
+			}
+		}
+		for _, m := range splitTag(field.Tag.Get("inject")) {
+			if !injectModifiers[m] {
+				problems = append(problems, fmt.Sprintf("%s.%s: unknown inject modifier %q", Name(a), field.Name, m))
+			}
+		}
+		for _, c := range splitTag(field.Tag.Get("command")) {
+			if !commands[c] {
+				problems = append(problems, fmt.Sprintf("%s.%s: unknown command %q", Name(a), field.Name, c))
+			}
+		}
+	}
+	return problems
+}
+
+// ServiceID identifies a registered service instance by its name, see
+// NamedService, or its type. If several instances have the same name
+// they are numbered.
+func (r *Registry) ServiceID(si ServiceAPI) string {
+	r.pool.mu.Lock()
+	defer r.pool.mu.Unlock()
+	return r.pool.id(si)
+}
+
+// ServiceID identifies a service instance of the default registry
+func ServiceID(si ServiceAPI) string {
+	return Default.ServiceID(si)
+}
+
+// A ServiceBinding is a service selected to be injected
+// into a field of an artifact
+type ServiceBinding struct {
+	Field   string
+	Service ServiceAPI
+	Shared  bool // the service is shared by the commands of the artifact
+}
+
+// bindServices selects the services that would be injected into the
+// fields of the artifact for the canonical command cmd now, like
+// reserve would. It also returns an InjectionError for each required
+// field no registered service satisfies.
+func (r *Registry) bindServices(cmd string, a Artifact) ([]ServiceBinding, []*InjectionError, error) {
+	id, meth, err := splitCommand(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.pool.mu.Lock()
+	reused, reqs, _ := r.pool.split(id, commandRequirements(a, meth))
+	r.pool.mu.Unlock()
+	var res []ServiceBinding
+	for _, al := range reused {
+		res = append(res, ServiceBinding{Field: al.Field, Service: al.owner.service, Shared: true})
+	}
+	candsOf, missing, err := r.pool.match(a, reqs)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.pool.probe(candsOf)
+	r.pool.mu.Lock()
+	defer r.pool.mu.Unlock()
+	for i, req := range reqs {
+		if selected := best(candsOf[i]); selected != nil {
+			res = append(res, ServiceBinding{Field: req.Field, Service: selected.service, Shared: req.Shared})
+		}
+	}
+	return res, missing, nil
+}
+
+// inject sets the bound services in the fields of the artifact
+func inject(a Artifact, bindings []ServiceBinding) {
+	v := reflect.ValueOf(a).Elem()
+	for _, b := range bindings {
+		v.FieldByName(b.Field).Set(reflect.ValueOf(b.Service))
+	}
+}
+
+// Services returns the service pool of the registry
+func (r *Registry) Services() *ServicePool {
+	return r.pool
+}
+
+// Services returns the service pool of the default registry
+func Services() *ServicePool {
+	return Default.pool
 }
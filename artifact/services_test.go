@@ -0,0 +1,169 @@
+package artifact
+
+import (
+	"strings"
+	"testing"
+)
+
+// Machine is the service interface of the test artifacts
+type Machine interface {
+	ServiceAPI
+	Host() string
+}
+
+// A plainMachine satisfies requirements naming its host
+type plainMachine struct {
+	host string
+}
+
+func (m *plainMachine) Allocate() int                     { return 1 }
+func (m *plainMachine) Deallocate(token int)              {}
+func (m *plainMachine) IsAvailable() bool                 { return true }
+func (m *plainMachine) Satisfies(requirement string) bool { return requirement == "host="+m.host }
+func (m *plainMachine) Host() string                      { return m.host }
+
+// A propMachine publishes its properties and score
+type propMachine struct {
+	plainMachine
+	props Properties
+	score int
+}
+
+func (m *propMachine) Properties() Properties { return m.props }
+func (m *propMachine) Score() int             { return m.score }
+
+// Satisfies is never called, the properties are matched instead
+func (m *propMachine) Satisfies(requirement string) bool { return true }
+
+type Compile struct {
+	BaseArtifact
+	Machine Machine `requirement:"cpus >= 4, os=linux"`
+	host    string
+}
+
+func (c *Compile) Build() { c.host = c.Machine.Host() }
+
+type Deploy struct {
+	BaseArtifact
+	Machine Machine `requirement:"host=prod"`
+	host    string
+}
+
+func (d *Deploy) Run() { d.host = d.Machine.Host() }
+
+// injectionError returns the InjectionError of the
+// only command failing in the build, if any
+func injectionError(err error) *InjectionError {
+	be, ok := err.(*BuildError)
+	if !ok || len(be.Errors) != 1 {
+		return nil
+	}
+	ce, ok := be.Errors[0].(*CommandError)
+	if !ok {
+		return nil
+	}
+	ie, _ := ce.Err.(*InjectionError)
+	return ie
+}
+
+func TestServiceSelection(t *testing.T) {
+	linux := func(host string, cpus string, score int) *propMachine {
+		return &propMachine{plainMachine{host}, Properties{"os": "linux", "cpus": cpus}, score}
+	}
+	tests := []struct {
+		name     string
+		services []ServiceAPI
+		want     string // the host injected, or "" if the build fails
+	}{
+		{"only the matching service",
+			[]ServiceAPI{linux("small", "2", 10), linux("big", "8", 1)}, "big"},
+		{"the highest score",
+			[]ServiceAPI{linux("big", "8", 1), linux("bigger", "16", 5)}, "bigger"},
+		{"the first registered of the same score",
+			[]ServiceAPI{linux("first", "8", 3), linux("second", "16", 3)}, "first"},
+		{"unscored services have score 0",
+			[]ServiceAPI{&plainMachine{"plain"}, linux("scored", "8", 0), linux("better", "8", 1)}, "better"},
+		{"properties are matched instead of asking",
+			[]ServiceAPI{&propMachine{plainMachine{"windows"}, Properties{"os": "windows", "cpus": "32"}, 0}}, ""},
+		{"services without properties are asked",
+			[]ServiceAPI{&plainMachine{"plain"}}, ""},
+	}
+	for _, tt := range tests {
+		r := unstampedRegistry()
+		c := new(Compile)
+		r.Add(c)
+		for _, s := range tt.services {
+			r.RegisterServiceInstance(s)
+		}
+		err := r.Call("Compile.Build")
+		switch {
+		case tt.want == "" && injectionError(err) == nil:
+			t.Errorf("%s: Call = %v, want an InjectionError", tt.name, err)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case c.host != tt.want:
+			t.Errorf("%s: got %q injected, want %q", tt.name, c.host, tt.want)
+		}
+		if c.Machine != nil {
+			t.Errorf("%s: the service is still injected after the command", tt.name)
+		}
+	}
+
+	// Satisfies decides for services without properties
+	r := unstampedRegistry()
+	d := new(Deploy)
+	r.Add(d)
+	r.RegisterServiceInstance(&plainMachine{"test"})
+	r.RegisterServiceInstance(&plainMachine{"prod"})
+	if err := r.Call("Deploy.Run"); err != nil {
+		t.Fatal(err)
+	}
+	if d.host != "prod" {
+		t.Errorf("got %q injected, want prod", d.host)
+	}
+}
+
+type Package struct {
+	BaseArtifact
+	Machine Machine `requirement:"os=windows"`
+	Signer  Machine `requirement:"host=signer" inject:"optional"`
+}
+
+func (p *Package) Run() {}
+
+type Broken struct {
+	BaseArtifact
+	Machine Machine `requirement:"os in linux"`
+}
+
+func (b *Broken) Run() {}
+
+func TestPlanServices(t *testing.T) {
+	r := unstampedRegistry()
+	r.Add(new(Compile), new(Package), new(Broken))
+	r.RegisterServiceInstance(&propMachine{plainMachine{"linux"}, Properties{"os": "linux", "cpus": "8"}, 0})
+	steps, err := r.Plan(nil, "Compile.Build", "Package.Run", "Broken.Run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 {
+		t.Fatalf("got %d steps, want 3", len(steps))
+	}
+	for _, st := range steps {
+		switch st.Cmd {
+		case "Compile.Build":
+			if len(st.Services) != 1 || st.Services[0].Service.(Machine).Host() != "linux" || len(st.Missing) != 0 {
+				t.Errorf("Compile.Build binds %v, missing %v, want the linux machine", st.Services, st.Missing)
+			}
+		case "Package.Run":
+			// The optional Signer is not missing
+			if len(st.Services) != 0 || len(st.Missing) != 1 || st.Missing[0].Field != "Machine" {
+				t.Errorf("Package.Run binds %v, missing %v, want Machine missing", st.Services, st.Missing)
+			}
+		case "Broken.Run":
+			if !strings.HasPrefix(st.Reason, "would fail: Broken.Machine") {
+				t.Errorf("Broken.Run reason %q, want the invalid requirement", st.Reason)
+			}
+		}
+	}
+}
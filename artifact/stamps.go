@@ -0,0 +1,138 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// The stamp of a command holds the signature of everything the command
+// depended on the last time it was executed successfully. The signature
+// is a hash of the command and its arguments, the files and workspace
+// variables it declares as inputs and the signatures of its dependencies.
+// A command is executed again as soon as its signature changes.
+
+// UsesVars declares workspace variables that affect the result of cmd
+// UsesVars("AMBuilder.Configure", "HOST", "TARGET")
+func (r *Registry) UsesVars(cmd string, vars ...string) {
+	r.inputVars[cmd] = append(r.inputVars[cmd], vars...)
+}
+
+// UsesVars declares workspace variables that affect the
+// result of cmd in the default registry
+func UsesVars(cmd string, vars ...string) {
+	Default.UsesVars(cmd, vars...)
+}
+
+// signature calculates the signature of the node. The signatures
+// of the dependencies must already be calculated.
+func (r *Registry) signature(n *node) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "cmd %s\n", n.cmd)
+	for _, a := range n.args {
+		fmt.Fprintf(h, "arg %q\n", a)
+	}
+	var declared []string
+	for d := range r.inputVars {
+		declared = append(declared, d)
+	}
+	var vars []string
+	for _, d := range r.sameCommand(n.cmd, declared) {
+		vars = append(vars, r.inputVars[d]...)
+	}
+	sort.Strings(vars)
+	for _, v := range vars {
+		val, ok := workspace.Get(v)
+		fmt.Fprintf(h, "var %s %t %q\n", v, ok, val)
+	}
+	for _, f := range expand(r.declarations(r.inputFiles, n.cmd)) {
+		hashPath(h, f)
+	}
+	for _, d := range n.deps {
+		fmt.Fprintf(h, "dep %s %s\n", d.cmd, d.sig)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashPath adds the content of the file, or of all files
+// below the directory, to the hash
+func hashPath(h hash.Hash, path string) {
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "file %s\n", p)
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(h, "file %s missing\n", path)
+	}
+}
+
+// stampDir returns the directory of the stamp files, and creates
+// it if it does not exist
+func (r *Registry) stampDir() (string, error) {
+	if r.StampDir == "" {
+		return workspace.GetStampDirPath()
+	}
+	if err := os.MkdirAll(r.StampDir, 0777); err != nil {
+		return "", fmt.Errorf("error when creating %s: %v", r.StampDir, err)
+	}
+	return r.StampDir, nil
+}
+
+// readStamp returns the signature recorded for cmd, if any
+func (r *Registry) readStamp(cmd string) (string, bool) {
+	sd, err := r.stampDir()
+	if err != nil {
+		return "", false
+	}
+	stamp, err := os.ReadFile(filepath.Join(sd, cmd))
+	if err != nil {
+		return "", false
+	}
+	return string(bytes.TrimSpace(stamp)), true
+}
+
+// isDone checks if a command already is executed with
+// the same signature, and that its outputs still exist
+func (r *Registry) isDone(cmd string, sig string) bool {
+	if r.IgnoreStamps {
+		return false
+	}
+	stamp, ok := r.readStamp(cmd)
+	return ok && stamp == sig && len(r.missingOutputs(cmd)) == 0
+}
+
+// Mark a command as done in the stamp dir
+func (r *Registry) markDone(cmd string, sig string) error {
+	if r.IgnoreStamps {
+		return nil
+	}
+	sd, err := r.stampDir()
+	if err != nil {
+		return err
+	}
+	f := filepath.Join(sd, cmd)
+	if err := os.WriteFile(f, []byte(sig+"\n"), 0666); err != nil {
+		return fmt.Errorf("error creating stamp file %s: %v", f, err)
+	}
+	return nil
+}
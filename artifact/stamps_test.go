@@ -0,0 +1,124 @@
+package artifact
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/staffano/crazy-build/workspace"
+)
+
+type Counter struct {
+	BaseArtifact
+	runs map[string]int
+}
+
+func (c *Counter) Generate()            { c.runs["Generate"]++ }
+func (c *Counter) Build(args ...string) { c.runs["Build"]++ }
+
+// testWorkspace makes a temporary directory the workspace root
+func testWorkspace(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, workspace.WspConfigFolder), 0777); err != nil {
+		t.Fatal(err)
+	}
+	old := workspace.WorkspaceRoot
+	workspace.WorkspaceRoot = dir
+	t.Cleanup(func() { workspace.WorkspaceRoot = old })
+	return dir
+}
+
+func TestStamps(t *testing.T) {
+	dir := testWorkspace(t)
+	input := filepath.Join(dir, "input.txt")
+	write := func(content string) {
+		if err := os.WriteFile(input, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("one")
+
+	c := &Counter{runs: make(map[string]int)}
+	r := NewRegistry()
+	r.Add(c)
+	r.Inputs("Counter.Generate", input)
+	r.Depends("Counter.Build", "Counter.Generate")
+
+	tests := []struct {
+		name   string
+		change func()
+		args   []string
+		want   map[string]int // the commands executed
+	}{
+		{"first call", func() {}, nil, map[string]int{"Generate": 1, "Build": 1}},
+		{"nothing changed", func() {}, nil, map[string]int{}},
+		{"arguments changed", func() {}, []string{"-v"}, map[string]int{"Build": 1}},
+		{"same arguments", func() {}, []string{"-v"}, map[string]int{}},
+		{"input changed", func() { write("two") }, []string{"-v"}, map[string]int{"Generate": 1, "Build": 1}},
+		{"input restored", func() { write("one") }, []string{"-v"}, map[string]int{"Generate": 1, "Build": 1}},
+		{"stamps ignored", func() { r.IgnoreStamps = true }, []string{"-v"}, map[string]int{"Generate": 1, "Build": 1}},
+	}
+	for _, tt := range tests {
+		tt.change()
+		c.runs = make(map[string]int)
+		if err := r.CallWithArgs(tt.args, "Counter.Build"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, cmd := range []string{"Generate", "Build"} {
+			if c.runs[cmd] != tt.want[cmd] {
+				t.Errorf("%s: Counter.%s executed %d times, want %d", tt.name, cmd, c.runs[cmd], tt.want[cmd])
+			}
+		}
+	}
+}
+
+func TestStampRecordsSignature(t *testing.T) {
+	testWorkspace(t)
+	r := NewRegistry()
+	r.Add(&Counter{runs: make(map[string]int)})
+	if err := r.Call("Counter.Generate"); err != nil {
+		t.Fatal(err)
+	}
+	stamp, ok := r.readStamp("Counter.Generate")
+	if !ok {
+		t.Fatal("no stamp recorded for Counter.Generate")
+	}
+	p := r.newPlan(nil, "Counter.Generate")
+	if sig := r.signature(p.order[0]); stamp != sig {
+		t.Errorf("stamp %q, want the signature %q", stamp, sig)
+	}
+	done, err := r.StampStatus("Counter.Generate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done["Counter.Generate"] {
+		t.Error("StampStatus does not report Counter.Generate as done")
+	}
+}
+
+func TestStampDir(t *testing.T) {
+	dir := testWorkspace(t)
+	r := NewRegistry()
+	r.StampDir = filepath.Join(t.TempDir(), "stamps")
+	r.Add(&Counter{runs: make(map[string]int)})
+	if err := r.Call("Counter.Generate"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(r.StampDir, "Counter.Generate")); err != nil {
+		t.Errorf("no stamp in the stamp dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, workspace.WspConfigFolder, workspace.StampDirName, "Counter.Generate")); err == nil {
+		t.Error("the stamp is also in the workspace")
+	}
+
+	// Another registry does not see the stamps
+	other := NewRegistry()
+	c := &Counter{runs: make(map[string]int)}
+	other.Add(c)
+	if err := other.Call("Counter.Generate"); err != nil {
+		t.Fatal(err)
+	}
+	if c.runs["Generate"] != 1 {
+		t.Errorf("Counter.Generate executed %d times in the other registry, want 1", c.runs["Generate"])
+	}
+}
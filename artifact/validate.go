@@ -0,0 +1,198 @@
+package artifact
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var closureRegExp = regexp.MustCompile(`\.func[0-9]+`)
+
+// commandName returns the name, like "PrintArtifact.Print", of a
+// method value like a.Print or method expression like (*PrintArtifact).Print
+func commandName(f interface{}) (string, error) {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return "", fmt.Errorf("%v is not a method value", f)
+	}
+	fn := runtime.FuncForPC(v.Pointer())
+	if fn == nil {
+		return "", fmt.Errorf("%v is not a method value", f)
+	}
+	// Like github.com/x/artifacts.(*PrintArtifact).Print-fm
+	full := fn.Name()
+	name := strings.TrimSuffix(full, "-fm")
+	methodValue := name != full
+	name = name[strings.LastIndex(name, "/")+1:]
+	// Closures are named like the function defining them, with .func1
+	if strings.Count(name, ".") < 2 || closureRegExp.MatchString(name) {
+		return "", fmt.Errorf("%s is not a method", full)
+	}
+	i := strings.LastIndex(name, ".")
+	rest, method := name[:i], name[i+1:]
+	typ := rest[strings.LastIndex(rest, ".")+1:]
+	typ = strings.TrimSuffix(strings.TrimPrefix(typ, "(*"), ")")
+	first := 0
+	if !methodValue {
+		// The receiver is the first parameter of method expressions
+		first = 1
+	}
+	if !isCommandFunc(v.Type(), first) {
+		return "", fmt.Errorf("%s.%s is not a command, commands take parameters parsable from "+
+			"command line arguments and return nothing or an error", typ, method)
+	}
+	return typ + "." + method, nil
+}
+
+// A ValidationError lists all problems found in the declared dependencies
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d problem(s) in the declared dependencies:\n\t%s",
+		len(e.Problems), strings.Join(e.Problems, "\n\t"))
+}
+
+// checkCommand returns a description of the problem if cmd does not
+// resolve to a command of a registered artifact, or is ambiguous
+func (r *Registry) checkCommand(cmd string) string {
+	name, c, err := splitCommand(cmd)
+	if err != nil {
+		return fmt.Sprintf("%q is not on the form artifact.command", cmd)
+	}
+	base, _ := splitName(name)
+	if len(r.Find(base)) == 0 {
+		var names []string
+		for _, ar := range r.All() {
+			base, _ := splitVersion(Name(ar))
+			names = append(names, Name(ar), base)
+		}
+		return fmt.Sprintf("unknown artifact %q in %s%s", base, cmd, suggest(base, names))
+	}
+	a, err := r.Resolve(name)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%s: %v", cmd, err)
+	case !hasCommand(a, c):
+		return fmt.Sprintf("artifact %s has no command %q%s", Name(a), c, suggest(c, GetCommands(a)))
+	}
+	return ""
+}
+
+// suggest returns a "did you mean" text with the candidate
+// closest to name, if any is close enough
+func suggest(name string, candidates []string) string {
+	best, bestDist := "", len(name)/3+2
+	for _, c := range candidates {
+		if d := distance(strings.ToLower(name), strings.ToLower(c)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// distance is the Levenshtein distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Validate checks that all commands in the declared dependencies, and
+// in the input and output declarations, are commands of registered
+// artifacts, with names that are not ambiguous and version constraints
+// that can be satisfied, and that the requirements matched against
+// service properties parse. It should be called after
+// RegisterConfigurationInterest, when all artifacts are registered.
+// All problems are reported in a *ValidationError, with suggestions
+// for misspelled names.
+func (r *Registry) Validate() error {
+	var cmds []string
+	for cmd := range r.dependencies {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+
+	var problems []string
+	for _, cmd := range cmds {
+		if p := r.checkCommand(cmd); p != "" {
+			for _, d := range r.dependencies[cmd] {
+				problems = append(problems, fmt.Sprintf("%s: %s", d.loc, p))
+			}
+		}
+		for _, d := range r.dependencies[cmd] {
+			if p := r.checkCommand(d.cmd); p != "" {
+				problems = append(problems, fmt.Sprintf("%s: %s", d.loc, p))
+			}
+		}
+	}
+	for _, decls := range []map[string][]fileDecl{r.inputFiles, r.outputFiles} {
+		var cmds []string
+		for cmd := range decls {
+			cmds = append(cmds, cmd)
+		}
+		sort.Strings(cmds)
+		for _, cmd := range cmds {
+			if p := r.checkCommand(cmd); p != "" {
+				problems = append(problems, fmt.Sprintf("%s: %s", decls[cmd][0].loc, p))
+			}
+		}
+	}
+	for _, a := range r.All() {
+		problems = append(problems, checkServiceTags(a)...)
+		for _, req := range ServiceRequirements(a) {
+			if err := r.pool.checkRequirement(req); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.%s: %v", Name(a), req.Field, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: unique(problems)}
+	}
+	return nil
+}
+
+// Validate checks the declarations of the default registry
+func Validate() error {
+	return Default.Validate()
+}
+
+// unique removes repeated strings, keeping the order
+func unique(strs []string) []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, s := range strs {
+		if !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
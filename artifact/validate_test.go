@@ -0,0 +1,125 @@
+package artifact
+
+import (
+	"strings"
+	"testing"
+)
+
+type Printer struct{ BaseArtifact }
+
+func (p *Printer) Print()                 {}
+func (p *Printer) Count() int             { return 0 }
+func (p *Printer) Closure() func()        { return func() {} }
+func (p *Printer) Options(opts ...string) {}
+
+func helper() {}
+
+func TestCommandName(t *testing.T) {
+	p, l := new(Printer), new(Loop)
+	local := func() {}
+	tests := []struct {
+		f    interface{}
+		want string // the name, or the error
+	}{
+		{p.Print, "Printer.Print"},
+		{p.Options, "Printer.Options"},
+		{(*Printer).Print, "Printer.Print"},
+		// A method of another artifact
+		{l.Ping, "Loop.Ping"},
+		{p.Count, "Printer.Count is not a command"},
+		{local, "TestCommandName.func1 is not a method"},
+		{p.Closure(), "(*Printer).Closure.func1 is not a method"},
+		{helper, "helper is not a method"},
+		{"Printer.Print", "is not a method value"},
+		{nil, "is not a method value"},
+	}
+	for _, tt := range tests {
+		name, err := commandName(tt.f)
+		switch {
+		case err != nil && !strings.Contains(err.Error(), tt.want):
+			t.Errorf("commandName(%v): %v, want %q", tt.f, err, tt.want)
+		case err == nil && name != tt.want:
+			t.Errorf("commandName(%v) = %q, want %q", tt.f, name, tt.want)
+		}
+	}
+}
+
+func TestDependsOn(t *testing.T) {
+	r := NewRegistry()
+	p, l := new(Printer), new(Loop)
+	r.Add(p, l)
+	r.DependsOn(p.Print, l.Ping, (*Loop).Pong)
+	if got := r.Dependencies("Printer.Print"); strings.Join(got, " ") != "Loop.Ping Loop.Pong" {
+		t.Errorf("Printer.Print depends on %v, want [Loop.Ping Loop.Pong]", got)
+	}
+
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.HasPrefix(msg, "DependsOn at ") || !strings.HasSuffix(msg, "is not a method") {
+			t.Errorf("DependsOn with a closure panicked with %q, want it to reject the closure", msg)
+		}
+	}()
+	r.DependsOn(p.Print, func() {})
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"build", "build", 0},
+		{"", "abc", 3},
+		{"build", "biuld", 2},
+		{"build", "buidl", 2},
+		{"build", "built", 1},
+		{"build", "rebuild", 2},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := distance(tt.b, tt.a); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"Build", "Install", "Configure", "Built"}
+	tests := []struct {
+		name string
+		want string // the suggested candidate, or ""
+	}{
+		// A transposition
+		{"Biuld", "Build"},
+		{"Confiugre", "Configure"},
+		// A wrong case
+		{"install", "Install"},
+		{"BUILD", "Build"},
+		// Beyond the threshold
+		{"Deploy", ""},
+		{"Inst", ""},
+		// Ties go to the first candidate
+		{"Buil", "Build"},
+		{"Builx", "Build"},
+	}
+	for _, tt := range tests {
+		want := ""
+		if tt.want != "" {
+			want = `, did you mean "` + tt.want + `"?`
+		}
+		if got := suggest(tt.name, candidates); got != want {
+			t.Errorf("suggest(%q) = %q, want %q", tt.name, got, want)
+		}
+	}
+
+	// Validate suggests the closest command
+	r := NewRegistry()
+	r.Add(new(Printer))
+	r.Depends("Printer.Prnit", "Printer.Print")
+	if err := r.Validate(); err == nil || !strings.Contains(err.Error(), `did you mean "Print"?`) {
+		t.Errorf("Validate = %v, want a suggestion", err)
+	}
+}
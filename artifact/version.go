@@ -0,0 +1,180 @@
+package artifact
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, like 1.2.3-rc.1+linux, or a git
+// commit of an artifact that is not released, like git:abc123
+type Version struct {
+	Major int
+	Minor int
+	Micro int
+	// Prerelease identifiers separated by dots, like rc.1
+	Prerelease string
+	// Build metadata, ignored when ordering versions
+	Build string
+	Git   string
+}
+
+// A Versioned artifact declares its version explicitly, instead
+// of encoding it in the type name
+type Versioned interface {
+	Version() Version
+}
+
+func (v Version) String() string {
+	if v.Git != "" {
+		return "git:" + v.Git
+	}
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Micro)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// NullVersion ...
+var NullVersion = Version{Major: 0, Minor: 0, Micro: 0, Git: ""}
+
+var semverRegExp = regexp.MustCompile(`^[vV]?(0|[1-9][0-9]*)(?:\.(0|[1-9][0-9]*))?(?:\.(0|[1-9][0-9]*))?` +
+	`(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+
+// ParseVersion parses a version like 1.2.3, v1.2, 1.0.0-beta.2+exp.sha.5114f85
+// or git:abc123. Missing minor and micro parts are 0.
+func ParseVersion(s string) (Version, error) {
+	if strings.HasPrefix(s, "git:") {
+		if len(s) == len("git:") {
+			return NullVersion, fmt.Errorf("invalid version %q, missing git hash", s)
+		}
+		return Version{Git: strings.TrimPrefix(s, "git:")}, nil
+	}
+	m := semverRegExp.FindStringSubmatch(s)
+	if m == nil {
+		return NullVersion, fmt.Errorf("invalid version %q", s)
+	}
+	var v Version
+	v.Major, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		v.Minor, _ = strconv.Atoi(m[2])
+	}
+	if m[3] != "" {
+		v.Micro, _ = strconv.Atoi(m[3])
+	}
+	v.Prerelease, v.Build = m[4], m[5]
+	return v, nil
+}
+
+// MustParseVersion is like ParseVersion but panics if the version
+// is invalid, for Versioned artifacts returning a constant version
+func MustParseVersion(s string) Version {
+	v, err := ParseVersion(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or higher
+// than o, in semantic version order. A prerelease is lower than the
+// release, and build metadata is ignored. Git versions are lower than
+// all numbered versions.
+func (v Version) Compare(o Version) int {
+	switch {
+	case v.Git != "" && o.Git == "":
+		return -1
+	case v.Git == "" && o.Git != "":
+		return 1
+	case v.Git != "":
+		return strings.Compare(v.Git, o.Git)
+	}
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Micro - o.Micro} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+// comparePrerelease orders prerelease identifiers. Numeric identifiers
+// are compared as numbers and are lower than alphanumeric ones, and a
+// shorter list is lower when all its identifiers are equal.
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// Stable tells if the version is a numbered release,
+// not a prerelease or a git commit
+func (v Version) Stable() bool {
+	return v.Git == "" && v.Prerelease == ""
+}
+
+var versionRegExp = regexp.MustCompile(`^[vV]([0-9]+)[vV]*([0-9]*)[vV]*([0-9]*)`)
+var gitRegExp = regexp.MustCompile(`^[vV]git[vV]([a-z0-9]*)[vV]`)
+
+// getVersion parses a version encoded in a type name, like V1v0v0
+func getVersion(str string) Version {
+	vre := versionRegExp.FindStringSubmatch(str)
+	if vre != nil {
+		minor := 0
+		micro := 0
+		major, _ := strconv.Atoi(vre[1])
+		if len(vre) > 2 {
+			minor, _ = strconv.Atoi(vre[2])
+		}
+		if len(vre) > 3 {
+			micro, _ = strconv.Atoi(vre[3])
+		}
+		return Version{Major: major, Minor: minor, Micro: micro, Git: ""}
+	}
+	gre := gitRegExp.FindStringSubmatch(str)
+	if gre != nil {
+		return Version{Major: 0, Minor: 0, Micro: 0, Git: gre[1]}
+	}
+	return NullVersion
+}
@@ -0,0 +1,116 @@
+package artifact
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Version
+		err  bool
+	}{
+		{"1.2.3", Version{Major: 1, Minor: 2, Micro: 3}, false},
+		{"v1.2", Version{Major: 1, Minor: 2}, false},
+		{"V10", Version{Major: 10}, false},
+		{"0.0.0", Version{}, false},
+		{"1.0.0-rc.1", Version{Major: 1, Prerelease: "rc.1"}, false},
+		{"1.0.0-beta.2+exp.sha.5114f85", Version{Major: 1, Prerelease: "beta.2", Build: "exp.sha.5114f85"}, false},
+		{"1.2.3+linux", Version{Major: 1, Minor: 2, Micro: 3, Build: "linux"}, false},
+		{"git:abc123", Version{Git: "abc123"}, false},
+		{"git:", NullVersion, true},
+		{"01.2.3", NullVersion, true},
+		{"1.2.3.4", NullVersion, true},
+		{"1.2.3-", NullVersion, true},
+		{"1.2.3-rc..1", NullVersion, true},
+		{"latest", NullVersion, true},
+		{"", NullVersion, true},
+	}
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.s)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseVersion(%q) error %v, want an error %v", tt.s, err, tt.err)
+		}
+		if v != tt.want {
+			t.Errorf("ParseVersion(%q) = %#v, want %#v", tt.s, v, tt.want)
+		}
+	}
+}
+
+func TestVersionOrder(t *testing.T) {
+	// Each version is lower than the next one
+	order := []string{
+		"git:abc",
+		"git:def",
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i, a := range order {
+		for j, b := range order {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := MustParseVersion(a).Compare(MustParseVersion(b)); got != want {
+				t.Errorf("%s compared to %s = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+
+	// Build metadata and missing parts do not change the order
+	for _, pair := range [][2]string{
+		{"1.2.3+linux", "1.2.3+windows"},
+		{"1.2.3+linux", "1.2.3"},
+		{"1.2", "1.2.0"},
+		{"v1", "1.0.0"},
+	} {
+		if got := MustParseVersion(pair[0]).Compare(MustParseVersion(pair[1])); got != 0 {
+			t.Errorf("%s compared to %s = %d, want 0", pair[0], pair[1], got)
+		}
+	}
+}
+
+func TestComparePrerelease(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"rc.1", "rc.1", 0},
+		// A release is higher than its prereleases
+		{"", "rc.1", 1},
+		{"rc.1", "", -1},
+		// Numeric identifiers compare as numbers
+		{"2", "11", -1},
+		{"beta.11", "beta.2", 1},
+		// and are lower than alphanumeric ones
+		{"1", "alpha", -1},
+		{"alpha", "1", 1},
+		{"alpha.1", "alpha.beta", -1},
+		// Alphanumeric identifiers compare in ASCII order
+		{"alpha", "beta", -1},
+		{"RC", "rc", -1},
+		// A shorter list is lower when the rest is equal
+		{"alpha", "alpha.1", -1},
+		{"alpha.1.2", "alpha.1", 1},
+	}
+	for _, tt := range tests {
+		if got := comparePrerelease(tt.a, tt.b); got != tt.want {
+			t.Errorf("comparePrerelease(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/staffano/crazy-build/artifact"
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// Command represents a command line command
+type Command struct {
+	ID    string
+	Short string
+	Long  string
+
+	// Flags registers the flags of the command, may be nil
+	Flags func(fs *flag.FlagSet)
+
+	// Subcommands of the command. If there are any, the first
+	// argument selects the subcommand to execute instead of Cmd.
+	Subcommands []Command
+
+	Cmd func(args ...string) error
+
+	fs *flag.FlagSet // created by flagSet
+}
+
+// findCommand returns the command with the id, or nil
+func findCommand(cmds []Command, id string) *Command {
+	for i := range cmds {
+		if cmds[i].ID == id {
+			return &cmds[i]
+		}
+	}
+	return nil
+}
+
+func commandIDs(cmds []Command) []string {
+	var res []string
+	for _, c := range cmds {
+		res = append(res, c.ID)
+	}
+	return res
+}
+
+// flagSet returns the flag set with the flags of the command
+func (c *Command) flagSet(path string) *flag.FlagSet {
+	if c.fs != nil {
+		return c.fs
+	}
+	c.fs = flag.NewFlagSet(path, flag.ContinueOnError)
+	if c.Flags != nil {
+		c.Flags(c.fs)
+	}
+	c.fs.Usage = func() { showCommandHelp(path, c) }
+	return c.fs
+}
+
+// run parses the flags of the command, or selects a subcommand,
+// and executes it. path is the command line leading to the command,
+// like "conf set".
+func (c *Command) run(path string, args []string) error {
+	if len(c.Subcommands) > 0 {
+		if len(args) == 0 {
+			return fmt.Errorf("%s needs a subcommand: %s", path, strings.Join(commandIDs(c.Subcommands), ", "))
+		}
+		sc := findCommand(c.Subcommands, args[0])
+		if sc == nil {
+			return fmt.Errorf("unknown subcommand %q, %s has: %s", args[0], path, strings.Join(commandIDs(c.Subcommands), ", "))
+		}
+		return sc.run(path+" "+sc.ID, args[1:])
+	}
+	rest, err := parseInterleaved(c.flagSet(path), args, false)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.Cmd(rest...)
+}
+
+// parseInterleaved parses the flags in args, also the ones following
+// positional arguments, and returns the positional arguments. Anything
+// after a "--" is positional, if keepDash is true the "--" is kept
+// among the positional arguments.
+func parseInterleaved(fs *flag.FlagSet, args []string, keepDash bool) ([]string, error) {
+	var res []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		consumed := len(args) - fs.NArg()
+		if consumed > 0 && args[consumed-1] == "--" {
+			if keepDash {
+				res = append(res, "--")
+			}
+			return append(res, fs.Args()...), nil
+		}
+		if fs.NArg() == 0 {
+			return res, nil
+		}
+		res = append(res, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// A FlagProvider is an artifact contributing its own flags
+// to the command line
+type FlagProvider interface {
+	Flags(fs *flag.FlagSet)
+}
+
+var flagRegistrations []func(fs *flag.FlagSet)
+
+// RegisterFlags lets a package contribute flags to the global
+// command line flags. It must be called before Execute.
+func RegisterFlags(fn func(fs *flag.FlagSet)) {
+	flagRegistrations = append(flagRegistrations, fn)
+}
+
+// cliFlags are the global flags, created by globalFlags
+var cliFlags *flag.FlagSet
+
+// globalFlags returns the flag set with all global flags, contributed
+// by the build system packages and the registered artifacts
+func globalFlags() *flag.FlagSet {
+	if cliFlags != nil {
+		return cliFlags
+	}
+	fs := flag.NewFlagSet(programName(), flag.ContinueOnError)
+	fs.BoolVar(&VerboseFlag, "verbose", false, "Set to true for more verbose output.")
+	fs.BoolVar(&DryRunFlag, "dry-run", false, "Print what would be executed, without executing anything.")
+	artifact.Flags(fs)
+	workspace.Flags(fs)
+	for _, fn := range flagRegistrations {
+		fn(fs)
+	}
+	for _, a := range artifact.GetAll() {
+		if fp, ok := a.(FlagProvider); ok {
+			fp.Flags(fs)
+		}
+	}
+	fs.Usage = func() { showHelp(nil) }
+	cliFlags = fs
+	return fs
+}
+
+// programName is the name of the cbt binary
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
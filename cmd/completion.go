@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/staffano/crazy-build/artifact"
+)
+
+// completionCmds generate completion scripts for the shells
+var completionCmds = []Command{
+	{ID: "bash", Short: "Generate bash completion",
+		Long: "source <(cbt completion bash)",
+		Cmd:  func(args ...string) error { return printCompletion(bashCompletion) }},
+	{ID: "zsh", Short: "Generate zsh completion",
+		Long: "cbt completion zsh > \"${fpath[1]}/_cbt\"",
+		Cmd:  func(args ...string) error { return printCompletion(zshCompletion) }},
+	{ID: "fish", Short: "Generate fish completion",
+		Long: "cbt completion fish > ~/.config/fish/completions/cbt.fish",
+		Cmd:  func(args ...string) error { return printCompletion(fishCompletion) }},
+}
+
+// completionWord is a word to complete, with a description
+type completionWord struct {
+	word  string
+	descr string
+}
+
+// completionData is what can be completed on the command line
+type completionData struct {
+	prog    string
+	flags   []completionWord // global flags, without dashes
+	natives []Command
+	targets []completionWord // artifact commands
+}
+
+func collectCompletion() completionData {
+	d := completionData{prog: programName(), natives: nativeCmds}
+	globalFlags().VisitAll(func(f *flag.Flag) {
+		d.flags = append(d.flags, completionWord{f.Name, f.Usage})
+	})
+	for _, a := range artifact.GetAll() {
+		for _, c := range artifact.GetCommands(a) {
+			cmd := artifact.QualifiedName(a) + "." + c
+			d.targets = append(d.targets, completionWord{cmd, artifact.Description(cmd)})
+		}
+	}
+	return d
+}
+
+func printCompletion(gen func(completionData) string) error {
+	fmt.Print(gen(collectCompletion()))
+	return nil
+}
+
+// commandFlags returns the flags of a native command, with dashes
+func commandFlags(path string, c *Command) []string {
+	var res []string
+	if c.Flags != nil {
+		c.flagSet(path).VisitAll(func(f *flag.Flag) {
+			res = append(res, "--"+f.Name)
+		})
+	}
+	return res
+}
+
+func words(ws []completionWord, prefix string) []string {
+	var res []string
+	for _, w := range ws {
+		res = append(res, prefix+w.word)
+	}
+	return res
+}
+
+// identifier makes the program name usable in shell function names
+func identifier(prog string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, prog)
+}
+
+func bashCompletion(d completionData) string {
+	var b strings.Builder
+	fn := "_" + identifier(d.prog)
+	fmt.Fprintf(&b, "# bash completion for %s\n", d.prog)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	fmt.Fprintf(&b, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(&b, "    local cmd=\"\" i\n")
+	fmt.Fprintf(&b, "    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(&b, "        case \"${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(&b, "            -*) ;;\n")
+	fmt.Fprintf(&b, "            *) cmd=\"${COMP_WORDS[i]}\"; break ;;\n")
+	fmt.Fprintf(&b, "        esac\n")
+	fmt.Fprintf(&b, "    done\n")
+	fmt.Fprintf(&b, "    local words\n")
+	fmt.Fprintf(&b, "    case \"$cmd\" in\n")
+	for i := range d.natives {
+		nc := &d.natives[i]
+		ws := append(commandIDs(nc.Subcommands), commandFlags(nc.ID, nc)...)
+		fmt.Fprintf(&b, "        %s) words=%q ;;\n", nc.ID, strings.Join(ws, " "))
+	}
+	all := append(commandIDs(d.natives), words(d.targets, "")...)
+	all = append(all, words(d.flags, "--")...)
+	fmt.Fprintf(&b, "        \"\") words=%q ;;\n", strings.Join(all, " "))
+	targets := append(words(d.targets, ""), words(d.flags, "--")...)
+	fmt.Fprintf(&b, "        *) words=%q ;;\n", strings.Join(targets, " "))
+	fmt.Fprintf(&b, "    esac\n")
+	fmt.Fprintf(&b, "    COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	fmt.Fprintf(&b, "}\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", fn, d.prog)
+	return b.String()
+}
+
+func zshCompletion(d completionData) string {
+	var b strings.Builder
+	fn := "_" + identifier(d.prog)
+	fmt.Fprintf(&b, "#compdef %s\n", d.prog)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	fmt.Fprintf(&b, "    local cmd=\"\" w\n")
+	fmt.Fprintf(&b, "    for w in ${words[2,CURRENT-1]}; do\n")
+	fmt.Fprintf(&b, "        [[ $w == -* ]] || { cmd=$w; break }\n")
+	fmt.Fprintf(&b, "    done\n")
+	fmt.Fprintf(&b, "    case $cmd in\n")
+	for i := range d.natives {
+		nc := &d.natives[i]
+		ws := append(commandIDs(nc.Subcommands), commandFlags(nc.ID, nc)...)
+		fmt.Fprintf(&b, "        %s) compadd -- %s ;;\n", nc.ID, strings.Join(ws, " "))
+	}
+	all := append(commandIDs(d.natives), words(d.targets, "")...)
+	all = append(all, words(d.flags, "--")...)
+	fmt.Fprintf(&b, "        \"\") compadd -- %s ;;\n", strings.Join(all, " "))
+	targets := append(words(d.targets, ""), words(d.flags, "--")...)
+	fmt.Fprintf(&b, "        *) compadd -- %s ;;\n", strings.Join(targets, " "))
+	fmt.Fprintf(&b, "    esac\n")
+	fmt.Fprintf(&b, "}\n")
+	fmt.Fprintf(&b, "compdef %s %s\n", fn, d.prog)
+	return b.String()
+}
+
+func fishCompletion(d completionData) string {
+	var b strings.Builder
+	p := d.prog
+	fmt.Fprintf(&b, "# fish completion for %s\n", p)
+	fmt.Fprintf(&b, "complete -c %s -f\n", p)
+	for _, f := range d.flags {
+		fmt.Fprintf(&b, "complete -c %s -l %s -d %q\n", p, f.word, f.descr)
+	}
+	for i := range d.natives {
+		nc := &d.natives[i]
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %q\n", p, nc.ID, nc.Short)
+		for _, sc := range nc.Subcommands {
+			fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from %s' -a %s -d %q\n", p, nc.ID, sc.ID, sc.Short)
+		}
+		if nc.Flags != nil {
+			nc.flagSet(nc.ID).VisitAll(func(f *flag.Flag) {
+				fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from %s' -l %s -d %q\n", p, nc.ID, f.Name, f.Usage)
+			})
+		}
+	}
+	natives := strings.Join(commandIDs(d.natives), " ")
+	for _, t := range d.targets {
+		fmt.Fprintf(&b, "complete -c %s -n 'not __fish_seen_subcommand_from %s' -a %s -d %q\n", p, natives, t.word, t.descr)
+	}
+	return b.String()
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// confCmds are the subcommands of conf, reading and
+// editing the workspace configuration
+var confCmds = []Command{
+	{ID: "get", Short: "Print the value of a variable",
+		Long: "conf get KEY",
+		Cmd:  confGet},
+	{ID: "set", Short: "Set a variable in the config file",
+		Long: "conf set KEY VALUE\nThe value is stored in the config file.\nValues starting with a dash are given after --, like conf set CFLAGS -- -O2",
+		Cmd:  confSet},
+	{ID: "unset", Short: "Remove a variable from the config file",
+		Long: "conf unset KEY",
+		Cmd:  confUnset},
+	{ID: "list", Short: "List all variables and where they come from",
+		Long: "conf list",
+		Cmd:  confList},
+	{ID: "init", Short: "Initialize a workspace",
+		Long: "conf init [path]\nCreate a workspace in path, or in the current directory.",
+		Cmd:  confInit},
+}
+
+func confGet(args ...string) error {
+	if workspaceErr != nil {
+		return workspaceErr
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: conf get KEY")
+	}
+	v, ok := workspace.Get(args[0])
+	if !ok {
+		return fmt.Errorf("%s is not set", args[0])
+	}
+	fmt.Println(v)
+	return nil
+}
+
+func confSet(args ...string) error {
+	if workspaceErr != nil {
+		return workspaceErr
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: conf set KEY VALUE")
+	}
+	// The variable is stored, it would only be set until conf exits otherwise
+	workspace.SetVar(args[0], args[1], true)
+	return workspace.SaveConfig()
+}
+
+func confUnset(args ...string) error {
+	if workspaceErr != nil {
+		return workspaceErr
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: conf unset KEY")
+	}
+	if _, ok := workspace.Configuration().Vars[args[0]]; !ok {
+		return fmt.Errorf("%s is not set in %s", args[0], workspace.GetConfigFilePath())
+	}
+	workspace.UnsetVar(args[0], true)
+	return workspace.SaveConfig()
+}
+
+func confList(args ...string) error {
+	if workspaceErr != nil {
+		return workspaceErr
+	}
+	for _, k := range workspace.Vars() {
+		v, _ := workspace.Get(k)
+		src, _ := workspace.GetSource(k)
+		fmt.Printf("%-12s %s=%s\n", "["+string(src)+"]", k, v)
+	}
+	return nil
+}
+
+// confInit initializes a workspace in path, or the current directory
+func confInit(args ...string) error {
+	p := "."
+	switch len(args) {
+	case 0:
+	case 1:
+		p = args[0]
+	default:
+		return fmt.Errorf("usage: conf init [path]")
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(abs, workspace.WspConfigFolder)); err == nil {
+		return fmt.Errorf("%s is already a workspace", abs)
+	}
+	if err := workspace.InitWorkspace(abs); err != nil {
+		return err
+	}
+	fmt.Printf("Workspace initialized at %s\n", abs)
+	return nil
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/staffano/crazy-build/artifact"
+)
+
+// printPlan prints what would be executed for the targets, in order
+func printPlan(args []string, targets ...string) error {
+	steps, err := artifact.Plan(args, targets...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Plan for %s:\n", strings.Join(targets, " "))
+	for i, st := range steps {
+		action := "skip"
+		if st.Run {
+			action = "run"
+		}
+		cmd := st.Cmd
+		if len(st.Args) > 0 {
+			cmd += " " + strings.Join(st.Args, " ")
+		}
+		fmt.Printf("%4d. %-4s %s (%s)\n", i+1, action, cmd, st.Reason)
+		if len(st.Depends) > 0 {
+			fmt.Printf("            after %s\n", strings.Join(st.Depends, ", "))
+		}
+		for _, b := range st.Services {
+			shared := ""
+			if b.Shared {
+				shared = " (shared)"
+			}
+			fmt.Printf("            binds %s to %s%s\n", b.Field, artifact.ServiceID(b.Service), shared)
+		}
+		for _, m := range st.Missing {
+			// Indent the rejected services below the error
+			fmt.Printf("            fails, %s\n", strings.ReplaceAll(m.Error(), "\n\t\t", "\n                "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/staffano/crazy-build/artifact"
+)
+
+// graphFormat is the --format flag of graph
+var graphFormat string
+
+func graphFlags(fs *flag.FlagSet) {
+	fs.StringVar(&graphFormat, "format", "dot", "Output format: dot, mermaid or json")
+}
+
+// graph prints the dependency graph of the commands, or of all commands
+func graph(args ...string) error {
+	if workspaceErr != nil {
+		return workspaceErr
+	}
+	g, err := artifact.GetGraph(args...)
+	if err != nil {
+		return err
+	}
+	switch graphFormat {
+	case "dot":
+		fmt.Print(g.DOT())
+	case "mermaid":
+		fmt.Print(g.Mermaid())
+	case "json":
+		b, err := g.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	default:
+		return fmt.Errorf("unknown graph format %q, use dot, mermaid or json", graphFormat)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/staffano/crazy-build/artifact"
+)
+
+// help shows the global help, or the help of a native
+// command or an artifact
+// help [artifact[@version] | native command [subcommand]]
+func help(args ...string) error {
+	if len(args) == 0 {
+		showHelp(nil)
+		return nil
+	}
+	if nc := findCommand(nativeCmds, args[0]); nc != nil {
+		path := nc.ID
+		for _, a := range args[1:] {
+			sc := findCommand(nc.Subcommands, a)
+			if sc == nil {
+				return fmt.Errorf("unknown subcommand %q, %s has: %s", a, path, strings.Join(commandIDs(nc.Subcommands), ", "))
+			}
+			nc, path = sc, path+" "+sc.ID
+		}
+		showCommandHelp(path, nc)
+		return nil
+	}
+	if strings.Contains(args[0], "@") {
+		a, err := artifact.Resolve(args[0])
+		if err != nil {
+			return err
+		}
+		showHelp(a)
+		return nil
+	}
+	name := strings.Split(args[0], ".")[0]
+	a := artifact.Find(name)
+	if len(a) == 0 {
+		return fmt.Errorf("no artifact or native command named %q, see %s ls", name, programName())
+	}
+	for _, ar := range a {
+		showHelp(ar)
+	}
+	return nil
+}
+
+// a == nil => glbal help
+func showHelp(a artifact.Artifact) {
+	if a == nil {
+		showGlobalHelp()
+		return
+	}
+	name := artifact.QualifiedName(a)
+	fmt.Printf("%s (%s)\n", name, artifact.GetVersion(a))
+	if usage := a.Usage(); usage != "" {
+		fmt.Printf("\n%s\n", indent(usage, "    "))
+	}
+	fmt.Printf("\nCommands:\n")
+	for _, c := range artifact.GetCommands(a) {
+		cmd := name + "." + c
+		fmt.Printf("    %s\n", c)
+		if d := artifact.Description(cmd); d != "" {
+			fmt.Println(indent(d, "        "))
+		}
+		if deps := artifact.Dependencies(cmd); len(deps) > 0 {
+			fmt.Printf("        Depends on: %s\n", strings.Join(deps, ", "))
+		}
+	}
+	if reqs := artifact.ServiceRequirements(a); len(reqs) > 0 {
+		fmt.Printf("\nServices:\n")
+		for _, r := range reqs {
+			var details []string
+			if r.Name != "" {
+				details = append(details, "named "+r.Name)
+			}
+			if r.Optional {
+				details = append(details, "optional")
+			}
+			if r.Shared {
+				details = append(details, "shared")
+			}
+			if len(r.Commands) > 0 {
+				details = append(details, "for "+strings.Join(r.Commands, ", "))
+			}
+			line := fmt.Sprintf("    %s %s %q", r.Field, r.Type, r.Requirement)
+			if len(details) > 0 {
+				line += " (" + strings.Join(details, "; ") + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+func showGlobalHelp() {
+	fmt.Printf("Usage:\n")
+	fmt.Printf("    %s [flags] artifact.command... [-- args]\n", programName())
+	fmt.Printf("    %s [flags] native-command [command flags] [args]\n", programName())
+	fmt.Printf("\nNative commands:\n")
+	for _, nc := range nativeCmds {
+		fmt.Printf("    %-12s %s\n", nc.ID, nc.Short)
+	}
+	fmt.Printf("\nFlags:\n")
+	fs := globalFlags()
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
+	fs.SetOutput(nil)
+	fmt.Printf("\nArtifacts:\n")
+	for _, a := range artifact.GetAll() {
+		fmt.Printf("    %-30s %s\n", artifact.QualifiedName(a), strings.Join(artifact.GetCommands(a), ", "))
+	}
+	fmt.Printf("\nUse \"%s help artifact\" or \"%s help native-command\" for more information.\n",
+		programName(), programName())
+}
+
+// showCommandHelp shows the help of a native command
+func showCommandHelp(path string, c *Command) {
+	fmt.Printf("%s %s - %s\n", programName(), path, c.Short)
+	if c.Long != "" {
+		fmt.Printf("\n%s\n", indent(c.Long, "    "))
+	}
+	if len(c.Subcommands) > 0 {
+		fmt.Printf("\nSubcommands:\n")
+		for _, sc := range c.Subcommands {
+			fmt.Printf("    %-12s %s\n", sc.ID, sc.Short)
+		}
+	}
+	if c.Flags != nil {
+		fmt.Printf("\nFlags:\n")
+		fs := c.flagSet(path)
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+		fs.SetOutput(nil)
+	}
+}
+
+// indent every line of the text with prefix
+func indent(text string, prefix string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix)
+}
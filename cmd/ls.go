@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/staffano/crazy-build/artifact"
+)
+
+// lsCommand is a command as listed by ls
+type lsCommand struct {
+	Name    string   `json:"name"`
+	Done    bool     `json:"done"`
+	Depends []string `json:"depends,omitempty"`
+}
+
+// lsArtifact is an artifact as listed by ls
+type lsArtifact struct {
+	Name     string      `json:"name"`
+	Version  string      `json:"version"`
+	Commands []lsCommand `json:"commands"`
+}
+
+// listArtifacts collects all artifacts with their commands
+func listArtifacts() ([]lsArtifact, error) {
+	var cmds []string
+	for _, a := range artifact.GetAll() {
+		for _, c := range artifact.GetCommands(a) {
+			cmds = append(cmds, artifact.QualifiedName(a)+"."+c)
+		}
+	}
+	done, err := artifact.StampStatus(cmds...)
+	if err != nil {
+		return nil, err
+	}
+	var res []lsArtifact
+	for _, a := range artifact.GetAll() {
+		la := lsArtifact{Name: artifact.QualifiedName(a), Version: artifact.GetVersion(a).String()}
+		for _, c := range artifact.GetCommands(a) {
+			cmd := la.Name + "." + c
+			la.Commands = append(la.Commands, lsCommand{
+				Name:    c,
+				Done:    done[cmd],
+				Depends: artifact.Dependencies(cmd),
+			})
+		}
+		res = append(res, la)
+	}
+	return res, nil
+}
+
+// Flags of ls
+var lsTree, lsJSON bool
+
+func lsFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&lsTree, "tree", false, "Show the dependencies between the commands as a tree")
+	fs.BoolVar(&lsJSON, "json", false, "Output in JSON format")
+}
+
+// ls lists the artifacts, their commands and whether
+// the commands are done
+func ls(args ...string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("ls takes no arguments")
+	}
+	if workspaceErr != nil {
+		return workspaceErr
+	}
+	arties, err := listArtifacts()
+	if err != nil {
+		return err
+	}
+	switch {
+	case lsJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(arties)
+	case lsTree:
+		printTree(arties)
+	default:
+		for _, a := range arties {
+			fmt.Printf("%s (%s)\n", a.Name, a.Version)
+			for _, c := range a.Commands {
+				fmt.Println(strings.TrimRight(fmt.Sprintf("    %-20s %s", c.Name, doneMark(c.Done)), " "))
+			}
+		}
+	}
+	return nil
+}
+
+func doneMark(done bool) string {
+	if done {
+		return "[done]"
+	}
+	return ""
+}
+
+// printTree prints each command that no other command depends on,
+// with its dependencies below it
+func printTree(arties []lsArtifact) {
+	done := make(map[string]bool)
+	deps := make(map[string][]string)
+	isDep := make(map[string]bool)
+	var cmds []string
+	for _, a := range arties {
+		for _, c := range a.Commands {
+			cmd := a.Name + "." + c.Name
+			cmds = append(cmds, cmd)
+			done[cmd] = c.Done
+			deps[cmd] = c.Depends
+			for _, d := range c.Depends {
+				isDep[d] = true
+			}
+		}
+	}
+	sort.Strings(cmds)
+	var walk func(cmd string, depth int)
+	walk = func(cmd string, depth int) {
+		fmt.Println(strings.TrimRight(strings.Repeat("    ", depth)+cmd+" "+doneMark(done[cmd]), " "))
+		for _, d := range deps[cmd] {
+			walk(d, depth+1)
+		}
+	}
+	for _, cmd := range cmds {
+		if !isDep[cmd] {
+			walk(cmd, 0)
+		}
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/staffano/crazy-build/artifact"
+	"github.com/staffano/crazy-build/plugin"
+	"github.com/staffano/crazy-build/workspace"
 )
 
 // Flags
@@ -13,50 +17,146 @@ import (
 // VerboseFlag ...
 var VerboseFlag bool
 
-func init() {
+// DryRunFlag prints the execution plan instead of executing it
+var DryRunFlag bool
+
+var nativeCmds []Command
 
-	flag.BoolVar(&VerboseFlag, "verbose", false, "Set to true for more verbose output.")
+func init() {
+	nativeCmds = []Command{
+		{ID: "ls", Short: "List available artifacts",
+			Long:  "List all artifacts with their version, commands and whether the commands are done.",
+			Flags: lsFlags,
+			Cmd:   ls},
+		{ID: "conf", Short: "Configure the build system",
+			Long:        "Read and edit the variables in the workspace config file.",
+			Subcommands: confCmds},
+		{ID: "graph", Short: "Export the command dependency graph",
+			Long: "graph [--format dot|mermaid|json] [artifact.command...]\n" +
+				"Print the graph of the commands, or of all commands, with their stamp state and services.",
+			Flags: graphFlags,
+			Cmd:   graph},
+		{ID: "services", Short: "Show the service allocations",
+			Long: "services [--json]\n" +
+				"List the registered services with their capacity, and the commands of a running build\n" +
+				"that have them allocated or wait for them.",
+			Flags: servicesFlags,
+			Cmd:   services},
+		{ID: "help", Short: "Show help",
+			Long: "help [artifact[@version] | native command]\nShow this help, or the help of an artifact or a native command.",
+			Cmd:  help},
+		{ID: "completion", Short: "Generate shell completion",
+			Long:        "Print a completion script for the shell, for example\nsource <(cbt completion bash)",
+			Subcommands: completionCmds}}
 }
 
-// Command represents a command line command
-type Command struct {
-	ID    string
-	Short string
-	Long  string
-	Cmd   func(args ...string)
+// splitArgs splits the command line into target commands and the
+// arguments following a "--" separator
+func splitArgs(args []string) (targets []string, rest []string) {
+	for i, a := range args {
+		if a == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
 }
 
-var nativeCmds = []Command{
-	{ID: "ls", Short: "List available artifacts", Cmd: func(args ...string) {}},
-	{ID: "conf", Short: "Configure the build system", Cmd: func(args ...string) {}},
-	{ID: "help", Short: "Show help", Cmd: func(args ...string) {}}}
+// workspaceErr is set if the workspace could not be initialized.
+// Native commands not needing a workspace can still be executed.
+var workspaceErr error
+
+// validationErr is set if the declared dependencies are invalid
+var validationErr error
 
-// a == nil => glbal help
-func showHelp(a *artifact.Artifact) {
-	log.Printf("Help!")
+// initWorkspace initializes the workspace, registers the plugin
+// services, lets the artifacts register their configuration
+// interests and validates the declared dependencies
+func initWorkspace() {
+	workspaceErr = workspace.Init()
+	if workspaceErr == nil {
+		if err := plugin.Discover(artifact.Default); err != nil {
+			log.Print(err)
+		}
+		artifact.RegisterConfigurationInterest()
+	}
+	validationErr = artifact.Validate()
 }
 
-// Execute ...
-// cbt --workspace=232323 --verbose gcc build
-// cbt [flags] [artifact] cmd [aux args]
+// Execute parses the command line and executes the native command
+// or the artifact commands.
+// cbt --workspace=232323 --verbose AMBuilder.Configure -- --host=arm
+// cbt [flags] artifact.cmd... [-- aux args]
 // or...
-// cbt [flags] [native command] aux args
+// cbt [flags] [native command] [command flags] aux args
+// The aux args are passed to each of the artifact commands.
 func Execute() {
-
-	if len(flag.Args()) == 0 {
+	fs := globalFlags()
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if fs.NArg() == 0 {
 		showHelp(nil)
 		os.Exit(1)
 	}
 
 	// Check if the first argument is a native command
-	for _, nc := range nativeCmds {
-		if nc.ID == flag.Arg(0) {
-			nc.Cmd(flag.Args()[1:]...)
-			os.Exit(0)
+	if nc := findCommand(nativeCmds, fs.Arg(0)); nc != nil {
+		initWorkspace()
+		if validationErr != nil {
+			log.Print(validationErr)
 		}
+		if err := nc.run(nc.ID, fs.Args()[1:]); err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Global flags may also follow the artifact commands
+	rest, err := parseInterleaved(fs, fs.Args(), true)
+	if err != nil {
+		os.Exit(2)
+	}
+	initWorkspace()
+	if workspaceErr != nil {
+		log.Fatal(workspaceErr)
+	}
+	if validationErr != nil {
+		log.Fatal(validationErr)
+	}
+	targets, args := splitArgs(rest)
+	if DryRunFlag {
+		if err := printPlan(args, targets...); err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
-	for _, a := range flag.Args() {
-		artifact.Call(a)
+	// Release the services also when interrupted
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if err := artifact.Services().Interrupt(); err != nil {
+			log.Print(err)
+		}
+		os.Exit(1)
+	}()
+	err = artifact.CallWithArgs(args, targets...)
+	stopServices()
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+// stopServices stops the services started by the build
+func stopServices() {
+	if err := artifact.Services().Stop(); err != nil {
+		log.Print(err)
 	}
 }
 
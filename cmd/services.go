@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/staffano/crazy-build/artifact"
+)
+
+// servicesJSON is the --json flag of services
+var servicesJSON bool
+
+func servicesFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&servicesJSON, "json", false, "Output in JSON format")
+}
+
+// services lists the registered service instances with the
+// allocations and waiting commands of a build running in the
+// workspace
+func services(args ...string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("services takes no arguments")
+	}
+	if workspaceErr != nil {
+		return workspaceErr
+	}
+	st := artifact.Services().Status()
+	running, err := artifact.ReadPoolStatus()
+	if err != nil {
+		return err
+	}
+	if running != nil {
+		published := make(map[string]artifact.ServiceStatus)
+		for _, s := range running.Services {
+			published[s.Service] = s
+		}
+		for i, s := range st.Services {
+			if p, ok := published[s.Service]; ok {
+				st.Services[i].Available, st.Services[i].Allocations = p.Available, p.Allocations
+				st.Services[i].State = p.State
+			}
+		}
+		st.Pid, st.Waiting = running.Pid, running.Waiting
+	}
+	if servicesJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+	if len(st.Services) == 0 {
+		fmt.Println("No services registered")
+		return nil
+	}
+	now := time.Now()
+	for _, s := range st.Services {
+		capacity := "unlimited"
+		if s.Capacity > 0 {
+			capacity = fmt.Sprint(s.Capacity)
+		}
+		state := ""
+		if s.State != "" {
+			state = ", " + s.State
+		}
+		fmt.Printf("%s (%d/%s in use%s)%s\n", s.Service, len(s.Allocations), capacity, state, properties(s.Service))
+		for _, al := range s.Allocations {
+			field := al.Field
+			if al.Shared {
+				field += " (shared)"
+			}
+			fmt.Printf("    %-30s %-12s %s\n", al.Cmd, field, age(now, al.Since))
+		}
+	}
+	if len(st.Waiting) > 0 {
+		fmt.Println("Waiting:")
+		for _, w := range st.Waiting {
+			fmt.Printf("    %-30s %-12s %-20q %s\n", w.Cmd, w.Field, w.Requirement, age(now, w.Since))
+		}
+	}
+	return nil
+}
+
+// properties returns the properties published by the registered
+// service with the ID, formatted as " key=value ..."
+func properties(id string) string {
+	var res []string
+	for _, s := range artifact.Services().Instances() {
+		if artifact.ServiceID(s) != id {
+			continue
+		}
+		if ps, ok := s.(artifact.PropertyService); ok {
+			for k, v := range ps.Properties() {
+				res = append(res, k+"="+v)
+			}
+		}
+	}
+	sort.Strings(res)
+	if len(res) == 0 {
+		return ""
+	}
+	return " " + strings.Join(res, " ")
+}
+
+// age returns the time passed since t, in seconds
+func age(now, t time.Time) string {
+	return now.Sub(t).Round(time.Second).String()
+}
@@ -2,11 +2,10 @@ package dockermachine
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"path/filepath"
 
-	normalLog "log"
-
 	"github.com/docker/docker/client"
 	dockerClient "github.com/docker/docker/client"
 	"github.com/docker/go-connections/tlsconfig"
@@ -50,16 +49,20 @@ type VirtualBoxDockerMachineV1v0v0 struct {
 }
 
 // Init ...
-func (a VirtualBoxDockerMachineV1v0v0) Init() {
+func (a VirtualBoxDockerMachineV1v0v0) Init() error {
 	// Initialize docker machines so we have something to execute
 	// our containers on
-	if !a.Exists(DefaultMachineName) {
+	exists, err := a.Exists(DefaultMachineName)
+	if err != nil {
+		return err
+	}
+	if !exists {
 		err := a.CreateDefaultMachine()
 		if err != nil {
-			normalLog.Fatalf("Error creating default docker machine: %q", err.Error())
+			return fmt.Errorf("error creating default docker machine: %v", err)
 		}
 	}
-	a.StartDockerMachine()
+	return a.StartDockerMachine()
 }
 
 // GetBaseDir returns the base directory for our docker machine store
@@ -170,14 +173,10 @@ func (a VirtualBoxDockerMachineV1v0v0) StopDockerMachine(name ...string) error {
 }
 
 // Exists checks if a machine exists with this name
-func (a VirtualBoxDockerMachineV1v0v0) Exists(name string) bool {
+func (a VirtualBoxDockerMachineV1v0v0) Exists(name string) (bool, error) {
 	client := libmachine.NewClient(a.GetBaseDir(), a.GetMachineCertDir())
 	defer client.Close()
-	ex, err := client.Filestore.Exists(name)
-	if err != nil {
-		panic(err)
-	}
-	return ex
+	return client.Filestore.Exists(name)
 }
 
 // CreateClient returns a valid API client to the docker engine
@@ -230,7 +229,7 @@ func (a VirtualBoxDockerMachineV1v0v0) CreateClient(name ...string) (*client.Cli
 }
 
 // MachineDriver returns the name of the driver
-func (a VirtualBoxDockerMachineV1v0v0) MachineDriver(name ...string) string {
+func (a VirtualBoxDockerMachineV1v0v0) MachineDriver(name ...string) (string, error) {
 	client := libmachine.NewClient(a.GetBaseDir(), a.GetMachineCertDir())
 	defer client.Close()
 	var n string
@@ -241,7 +240,7 @@ func (a VirtualBoxDockerMachineV1v0v0) MachineDriver(name ...string) string {
 	}
 	host, err := client.Load(n)
 	if err != nil {
-		normalLog.Fatal(err)
+		return "", err
 	}
-	return host.DriverName
+	return host.DriverName, nil
 }
@@ -1,13 +1,10 @@
+//go:build ignore
 // +build ignore
 
 package main
 
 import (
-	"flag"
-
-	"github.com/staffano/crazy-build/artifact"
 	"github.com/staffano/crazy-build/cmd"
-	"github.com/staffano/crazy-build/workspace"
 )
 
 // Handling of configurations really needs two passes
@@ -19,9 +16,6 @@ import (
 // Instantiation is handled as a separate cmd
 
 func main() {
-	flag.Parse()
-	workspace.Init()
 	LoadArtifacts()
-	artifact.RegisterConfigurationInterest()
 	cmd.Execute()
 }
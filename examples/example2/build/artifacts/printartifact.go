@@ -24,5 +24,7 @@ func (a *PrintArtifact) Print2() {
 }
 
 func init() {
-	artifact.Depends("PrintArtifact.Print2", "PrintArtifact.Print")
+	artifact.DependsOn((*PrintArtifact).Print2, (*PrintArtifact).Print)
+	artifact.Describe("PrintArtifact.Print", "Prints Hello using Service1")
+	artifact.Describe("PrintArtifact.Print2", "Prints World using Service2")
 }
@@ -1,14 +1,12 @@
 package main
 
 import (
-	"flag"
 	"log"
 	"os"
 
 	"github.com/staffano/crazy-build/artifact"
 	"github.com/staffano/crazy-build/cmd"
 	"github.com/staffano/crazy-build/examples/example2/build/artifacts"
-	"github.com/staffano/crazy-build/workspace"
 )
 
 // Handling of configurations really needs two passes
@@ -21,9 +19,6 @@ import (
 
 func main() {
 	log.Printf("%v", os.Args)
-	flag.Parse()
-	workspace.Init()
 	artifact.Add(new(artifacts.PrintArtifact))
-	artifact.RegisterConfigurationInterest()
 	cmd.Execute()
 }
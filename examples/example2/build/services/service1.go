@@ -36,10 +36,17 @@ func (s *Service1Impl) PrintHello() {
 	log.Print("Hello")
 }
 
+// Properties are matched against the requirements of the artifacts
+func (s *Service1Impl) Properties() artifact.Properties {
+	return artifact.Properties{
+		"target": "x86_64-pc-linux-gnu",
+		"host":   "mipsel-unknown-linux",
+	}
+}
+
 // Satisfies checks if this instance satisfies the requiresments
 func (s *Service1Impl) Satisfies(req string) bool {
-
-	return true
+	return s.Properties().Satisfies(req)
 }
 
 func init() {
@@ -35,10 +35,23 @@ func (s *Service2Impl) PrintWorld() {
 	log.Print("World")
 }
 
+// Properties are matched against the requirements of the artifacts
+func (s *Service2Impl) Properties() artifact.Properties {
+	return artifact.Properties{
+		"target": "x86_64-pc-linux-gnu",
+		"host":   "mipsel-unknown-linux",
+	}
+}
+
+// Score makes Service2Impl preferred to other services
+// matching the same requirements
+func (s *Service2Impl) Score() int {
+	return 10
+}
+
 // Satisfies checks if this instance satisfies the requiresments
 func (s *Service2Impl) Satisfies(req string) bool {
-
-	return true
+	return s.Properties().Satisfies(req)
 }
 
 func init() {
@@ -0,0 +1,335 @@
+// Package plugin adapts services provided by external executables to
+// artifact.ServiceAPI, so services can be shared without compiling
+// them into every build binary.
+//
+// Each executable in the plugins directory of the workspace,
+// .crazy_build/plugins, is a plugin named by its file name. The plugin
+// reads JSON-RPC 1.0 requests from stdin and writes the responses to
+// stdout, one JSON object per request. The params of a request are an
+// array with one element. The plugin implements the methods
+//
+//	Service.IsAvailable {}            returns a bool
+//	Service.Allocate    {}            returns a token
+//	Service.Deallocate  {"token": 1}  returns true
+//
+// and any other Service methods the artifacts call, like Service.Sign.
+// A result may not be null, the error is null or a string.
+// Anything the plugin writes to stderr is shown in the build log. The
+// plugin exits when stdin is closed.
+//
+// A manifest next to the executable, named like it with a .json
+// suffix, may declare the properties, score and capacity of the plugin:
+//
+//	{"properties": {"os": "linux"}, "score": 10, "capacity": 2, "args": ["--verbose"]}
+//
+// The requirements of a plugin are matched against its properties,
+// which always include "plugin" with its name, without starting it. A
+// plugin is started when a command needing it runs, and stopped like
+// other artifact.LifecycleService services. A plugin failing
+// Service.Allocate is not allocated, and is unavailable until it is
+// stopped.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/staffano/crazy-build/artifact"
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// PluginAPI is the service interface of plugins. Artifacts get a
+// plugin injected into a field of this type, like
+//
+//	Signer plugin.PluginAPI `requirement:"plugin=signer"`
+type PluginAPI interface {
+	artifact.ServiceAPI
+
+	// Call the method of the plugin, like "Sign" for Service.Sign,
+	// and decode its result into reply
+	Call(method string, args interface{}, reply interface{}) error
+}
+
+// Manifest declares a plugin
+type Manifest struct {
+	Properties artifact.Properties `json:"properties,omitempty"`
+	Score      int                 `json:"score,omitempty"`
+	Capacity   int                 `json:"capacity,omitempty"` // 0 is unlimited
+	Args       []string            `json:"args,omitempty"`     // passed to the executable
+}
+
+// A Plugin is a service provided by an external executable
+type Plugin struct {
+	Name     string
+	Path     string
+	Manifest *Manifest // nil if the plugin has no manifest
+
+	mu     sync.Mutex
+	proc   *exec.Cmd
+	client *rpc.Client
+	err    error // why the plugin failed to be allocated
+}
+
+// Discover registers the plugins found in the plugins directory of
+// the workspace as services of the registry
+func Discover(r *artifact.Registry) error {
+	plugins, err := Find(workspace.GetPluginDirPath())
+	if err != nil {
+		return err
+	}
+	for _, p := range plugins {
+		r.RegisterServiceInstance(p)
+	}
+	return nil
+}
+
+// Find returns the plugins in the directory, which is
+// not required to exist
+func Find(dir string) ([]*Plugin, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res []*Plugin
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 || strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		p := &Plugin{Name: e.Name(), Path: filepath.Join(dir, e.Name())}
+		raw, err := os.ReadFile(p.Path + ".json")
+		if err == nil {
+			p.Manifest = new(Manifest)
+			if err := json.Unmarshal(raw, p.Manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest of plugin %s: %v", p.Name, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// ServiceName identifies the plugin among the services
+func (p *Plugin) ServiceName() string {
+	return "plugin:" + p.Name
+}
+
+// Properties returns the properties declared in the manifest, and
+// the name of the plugin
+func (p *Plugin) Properties() artifact.Properties {
+	props := artifact.Properties{"plugin": p.Name}
+	if p.Manifest != nil {
+		for k, v := range p.Manifest.Properties {
+			props[k] = v
+		}
+	}
+	return props
+}
+
+// Score returns the score declared in the manifest
+func (p *Plugin) Score() int {
+	if p.Manifest == nil {
+		return 0
+	}
+	return p.Manifest.Score
+}
+
+// Capacity returns the capacity declared in the manifest
+func (p *Plugin) Capacity() int {
+	if p.Manifest == nil {
+		return 0
+	}
+	return p.Manifest.Capacity
+}
+
+// Satisfies matches the requirement against the properties
+func (p *Plugin) Satisfies(requirement string) bool {
+	return p.Properties().Satisfies(requirement)
+}
+
+// IsAvailable asks the plugin, a plugin that is not started is
+// available and one that failed to be allocated is not
+func (p *Plugin) IsAvailable() bool {
+	p.mu.Lock()
+	started, failed := p.client != nil, p.err != nil
+	p.mu.Unlock()
+	if failed {
+		return false
+	}
+	if !started {
+		return true
+	}
+	var ok bool
+	if err := p.call("IsAvailable", struct{}{}, &ok); err != nil {
+		log.Print(err)
+		return false
+	}
+	return ok
+}
+
+// Allocate the plugin for an artifact
+func (p *Plugin) Allocate() int {
+	token, err := p.TryAllocate()
+	if err != nil {
+		log.Print(err)
+	}
+	return token
+}
+
+// TryAllocate allocates the plugin for an artifact. If the plugin
+// fails, it is unavailable until it is stopped.
+func (p *Plugin) TryAllocate() (int, error) {
+	var token int
+	if err := p.call("Allocate", struct{}{}, &token); err != nil {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+		return 0, err
+	}
+	return token, nil
+}
+
+// Deallocate the artifact from the plugin
+func (p *Plugin) Deallocate(token int) {
+	if err := p.call("Deallocate", map[string]int{"token": token}, nil); err != nil {
+		log.Print(err)
+	}
+}
+
+// Call the method of the plugin
+func (p *Plugin) Call(method string, args interface{}, reply interface{}) error {
+	return p.call(method, args, reply)
+}
+
+// Start starts the plugin process
+func (p *Plugin) Start(ctx context.Context) error {
+	_, err := p.connect()
+	return err
+}
+
+// Healthy returns nil when the plugin answers, and the error
+// of a failed allocation
+func (p *Plugin) Healthy(ctx context.Context) error {
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	var ok bool
+	return p.call("IsAvailable", struct{}{}, &ok)
+}
+
+// Stop stops the plugin process
+func (p *Plugin) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = nil
+	if p.client == nil {
+		return nil
+	}
+	// Closing stdin tells the plugin to exit
+	p.client.Close()
+	done := make(chan error, 1)
+	go func() { done <- p.proc.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		p.proc.Process.Kill()
+		err = <-done
+	}
+	p.client, p.proc = nil, nil
+	return err
+}
+
+// call calls Service.method of the plugin, starting it if needed
+func (p *Plugin) call(method string, args interface{}, reply interface{}) error {
+	client, err := p.connect()
+	if err != nil {
+		return err
+	}
+	err = client.Call("Service."+method, args, reply)
+	if _, ok := err.(rpc.ServerError); err != nil && !ok {
+		// The plugin exited or broke the protocol, it is
+		// started again by the next call
+		p.mu.Lock()
+		if p.client == client {
+			client.Close()
+			p.proc.Process.Kill()
+			p.proc.Wait()
+			p.client, p.proc = nil, nil
+		}
+		p.mu.Unlock()
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s: Service.%s: %v", p.Name, method, err)
+	}
+	return nil
+}
+
+// stdio is the stdin and stdout of a plugin process
+type stdio struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (s stdio) Close() error {
+	err := s.WriteCloser.Close()
+	s.ReadCloser.Close()
+	return err
+}
+
+// connect starts the plugin process, if not started,
+// and returns the client calling it
+func (p *Plugin) connect() (*rpc.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client != nil {
+		return p.client, nil
+	}
+	var args []string
+	if p.Manifest != nil {
+		args = p.Manifest.Args
+	}
+	proc := exec.Command(p.Path, args...)
+	proc.Dir = workspace.GetWorkspaceRoot()
+	proc.Stderr = os.Stderr
+	stdin, err := proc.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := proc.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := proc.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %v", p.Name, err)
+	}
+	p.proc = proc
+	p.client = jsonrpc.NewClient(stdio{stdout, stdin})
+	return p.client, nil
+}
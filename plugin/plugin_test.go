@@ -0,0 +1,337 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/staffano/crazy-build/artifact"
+	"github.com/staffano/crazy-build/workspace"
+)
+
+// The test binary serves as the plugin when CBT_TEST_PLUGIN is set
+func TestMain(m *testing.M) {
+	if os.Getenv("CBT_TEST_PLUGIN") != "" {
+		servePlugin()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Service is the service of the test plugin. It fails to be
+// allocated when started with the argument fail-allocate.
+type Service struct {
+	mu           sync.Mutex
+	failAllocate bool
+	allocated    map[int]bool
+	next         int
+}
+
+func (s *Service) IsAvailable(args struct{}, ok *bool) error {
+	*ok = true
+	return nil
+}
+
+func (s *Service) Allocate(args struct{}, token *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failAllocate {
+		return errors.New("out of licenses")
+	}
+	s.next++
+	s.allocated[s.next] = true
+	*token = s.next
+	return nil
+}
+
+func (s *Service) Deallocate(args map[string]int, ok *bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.allocated, args["token"])
+	*ok = true
+	return nil
+}
+
+// Allocated returns the number of allocations
+func (s *Service) Allocated(args struct{}, n *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*n = len(s.allocated)
+	return nil
+}
+
+func (s *Service) Sign(args map[string]string, signed *string) error {
+	*signed = "signed " + args["data"]
+	return nil
+}
+
+// Pid returns the process ID of the plugin
+func (s *Service) Pid(args struct{}, pid *int) error {
+	*pid = os.Getpid()
+	return nil
+}
+
+func servePlugin() {
+	s := &Service{allocated: make(map[int]bool)}
+	for _, arg := range os.Args[1:] {
+		s.failAllocate = s.failAllocate || arg == "fail-allocate"
+	}
+	server := rpc.NewServer()
+	server.RegisterName("Service", s)
+	server.ServeCodec(jsonrpc.NewServerCodec(stdio{os.Stdin, os.Stdout}))
+}
+
+// testPlugins creates a workspace with the test binary as the plugins,
+// with the manifests, or none for "", and returns the plugins directory
+func testPlugins(t *testing.T, manifests map[string]string) string {
+	root := t.TempDir()
+	dir := filepath.Join(root, workspace.WspConfigFolder, workspace.PluginDirName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	old := workspace.WorkspaceRoot
+	workspace.WorkspaceRoot = root
+	t.Cleanup(func() { workspace.WorkspaceRoot = old })
+	t.Setenv("CBT_TEST_PLUGIN", "1")
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, manifest := range manifests {
+		if err := os.WriteFile(filepath.Join(dir, name), raw, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if manifest == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// find returns the plugins in the directory by their names, and
+// stops them when the test is done
+func find(t *testing.T, dir string) map[string]*Plugin {
+	plugins, err := Find(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]*Plugin)
+	for _, p := range plugins {
+		res[p.Name] = p
+		p := p
+		t.Cleanup(func() { p.Stop(context.Background()) })
+	}
+	return res
+}
+
+func started(p *Plugin) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.client != nil
+}
+
+func TestFind(t *testing.T) {
+	dir := testPlugins(t, map[string]string{
+		"signer": `{"properties": {"os": "linux"}, "score": 3, "capacity": 2, "args": ["--verbose"]}`,
+		"plain":  "",
+	})
+	// Files that are not executable are not plugins
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plugins, err := Find(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range plugins {
+		names = append(names, p.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"plain", "signer"}) {
+		t.Fatalf("found %v, want [plain signer]", names)
+	}
+	plain, signer := plugins[0], plugins[1]
+
+	tests := []struct {
+		p        *Plugin
+		props    artifact.Properties
+		score    int
+		capacity int
+		args     []string
+	}{
+		{plain, artifact.Properties{"plugin": "plain"}, 0, 0, nil},
+		{signer, artifact.Properties{"plugin": "signer", "os": "linux"}, 3, 2, []string{"--verbose"}},
+	}
+	for _, tt := range tests {
+		if props := tt.p.Properties(); !reflect.DeepEqual(props, tt.props) {
+			t.Errorf("%s has properties %v, want %v", tt.p.Name, props, tt.props)
+		}
+		if tt.p.Score() != tt.score || tt.p.Capacity() != tt.capacity {
+			t.Errorf("%s has score %d and capacity %d, want %d and %d",
+				tt.p.Name, tt.p.Score(), tt.p.Capacity(), tt.score, tt.capacity)
+		}
+		if tt.p.Manifest != nil && !reflect.DeepEqual(tt.p.Manifest.Args, tt.args) {
+			t.Errorf("%s has args %v, want %v", tt.p.Name, tt.p.Manifest.Args, tt.args)
+		}
+	}
+
+	// The properties are matched without starting the plugins
+	for requirement, want := range map[string]bool{
+		"plugin=signer, os=linux": true,
+		"plugin=signer, os=win":   false,
+		"plugin=plain":            true,
+	} {
+		p := signer
+		if strings.Contains(requirement, "plain") {
+			p = plain
+		}
+		if got := p.Satisfies(requirement); got != want {
+			t.Errorf("%s satisfies %q = %v, want %v", p.Name, requirement, got, want)
+		}
+	}
+	for _, p := range plugins {
+		if !p.IsAvailable() || started(p) {
+			t.Errorf("%s is started, or not available before it is", p.Name)
+		}
+	}
+
+	if plugins, err := Find(filepath.Join(dir, "missing")); plugins != nil || err != nil {
+		t.Errorf("Find of a missing directory = %v, %v, want nothing", plugins, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plain.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Find(dir); err == nil || !strings.Contains(err.Error(), "invalid manifest of plugin plain") {
+		t.Errorf("Find = %v, want the manifest to be invalid", err)
+	}
+}
+
+func TestPluginCalls(t *testing.T) {
+	p := find(t, testPlugins(t, map[string]string{"signer": ""}))["signer"]
+
+	// The first call starts the plugin
+	token := p.Allocate()
+	if token != 1 || !started(p) {
+		t.Fatalf("Allocate = %d, started %v, want token 1 from the started plugin", token, started(p))
+	}
+	if !p.IsAvailable() {
+		t.Error("the started plugin is not available")
+	}
+	var n int
+	if err := p.Call("Allocated", struct{}{}, &n); err != nil || n != 1 {
+		t.Errorf("Allocated = %d, %v, want 1", n, err)
+	}
+	var signed string
+	if err := p.Call("Sign", map[string]string{"data": "abc"}, &signed); err != nil || signed != "signed abc" {
+		t.Errorf("Sign = %q, %v, want \"signed abc\"", signed, err)
+	}
+	p.Deallocate(token)
+	if err := p.Call("Allocated", struct{}{}, &n); err != nil || n != 0 {
+		t.Errorf("Allocated after Deallocate = %d, %v, want 0", n, err)
+	}
+
+	// An error of the plugin does not stop it
+	var pid int
+	if err := p.Call("Pid", struct{}{}, &pid); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Call("Missing", struct{}{}, nil); err == nil || !strings.Contains(err.Error(), "Service.Missing") {
+		t.Errorf("Missing = %v, want an error", err)
+	}
+	var again int
+	if err := p.Call("Pid", struct{}{}, &again); err != nil || again != pid {
+		t.Errorf("Pid = %d, %v, want %d", again, err, pid)
+	}
+
+	// A stopped plugin is started again when called
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if started(p) {
+		t.Error("the plugin is started after Stop")
+	}
+	if err := p.Call("Pid", struct{}{}, &again); err != nil || again == pid {
+		t.Errorf("Pid = %d, %v, want a new process", again, err)
+	}
+}
+
+func TestPluginAllocateFails(t *testing.T) {
+	p := find(t, testPlugins(t, map[string]string{"broken": `{"args": ["fail-allocate"]}`}))["broken"]
+	if _, err := p.TryAllocate(); err == nil || !strings.Contains(err.Error(), "out of licenses") {
+		t.Fatalf("TryAllocate = %v, want it to fail", err)
+	}
+	if p.IsAvailable() {
+		t.Error("the plugin is available after failing to be allocated")
+	}
+	if err := p.Healthy(context.Background()); err == nil {
+		t.Error("the plugin is healthy after failing to be allocated")
+	}
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsAvailable() {
+		t.Error("the plugin is not available after it is stopped")
+	}
+}
+
+// A Release is signed by a plugin
+type Release struct {
+	artifact.BaseArtifact
+	Signer    PluginAPI `requirement:"os=linux"`
+	signature string
+	err       error
+}
+
+func (r *Release) Sign() {
+	r.err = r.Signer.Call("Sign", map[string]string{"data": "release"}, &r.signature)
+}
+
+func TestPluginService(t *testing.T) {
+	plugins := find(t, testPlugins(t, map[string]string{
+		"broken": `{"properties": {"os": "linux"}, "score": 10, "args": ["fail-allocate"]}`,
+		"signer": `{"properties": {"os": "linux"}}`,
+	}))
+	r := artifact.NewRegistry()
+	r.IgnoreStamps = true
+	rel := new(Release)
+	r.Add(rel)
+	r.RegisterServiceInstance(plugins["broken"])
+	r.RegisterServiceInstance(plugins["signer"])
+
+	// Planning does not start the plugins
+	steps, err := r.Plan(nil, "Release.Sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 1 || len(steps[0].Services) != 1 || steps[0].Services[0].Service != plugins["broken"] {
+		t.Errorf("plan %+v, want Release.Sign bound to the broken plugin, which scores higher", steps)
+	}
+	for name, p := range plugins {
+		if started(p) {
+			t.Errorf("%s is started by the plan", name)
+		}
+	}
+
+	// The signer is used when the broken plugin fails to be allocated
+	if err := r.Call("Release.Sign"); err != nil {
+		t.Fatal(err)
+	}
+	if rel.err != nil || rel.signature != "signed release" {
+		t.Errorf("Sign = %q, %v, want \"signed release\"", rel.signature, rel.err)
+	}
+}
@@ -5,19 +5,39 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 // Config is the model of the configuration file
 type Config struct {
 	Vars map[string]string `json:"env,omitempty"`
+	// Versions pins the version constraint of artifact names
+	// used without one, like "gcc": ">=1.2,<2"
+	Versions map[string]string `json:"versions,omitempty"`
 }
 
 var variables map[string]string
 
+// Source tells where the value of a variable comes from
+type Source string
+
+const (
+	// FromConfig is a variable set in the config file
+	FromConfig Source = "config"
+	// FromEnvironment is a variable set in the OS environment
+	FromEnvironment Source = "environment"
+	// Automatic is a variable set by the build system
+	Automatic Source = "automatic"
+	// FromSetVar is a variable set by SetVar without persisting it
+	FromSetVar Source = "set"
+)
+
+var sources map[string]Source
+
 var configuration *Config
 
 // WspConfigFolder is the workspace config folder that marks the
@@ -28,6 +48,14 @@ const WspConfigFolder string = ".crazy_build"
 // configuration
 const ConfigFile string = "config.json"
 
+// ServicesFile is the filename of the file where a running build
+// publishes the allocations of its services
+const ServicesFile string = "services.json"
+
+// PluginDirName is the directory containing the executables
+// of plugin services
+const PluginDirName string = "plugins"
+
 // StampDirName is the directory containing all stamps,
 // which is markers that something has been done successfully
 const StampDirName string = "stamps"
@@ -70,15 +98,35 @@ func GetWorkspaceRoot(d ...string) string {
 
 // GetStampDirPath returns the path within workspace config folder that
 // contains stamp files
-func GetStampDirPath() string {
+func GetStampDirPath() (string, error) {
 	dir := filepath.Join(GetWorkspaceRoot(), WspConfigFolder, StampDirName)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		err = os.MkdirAll(dir, 0777)
 		if err != nil {
-			log.Fatalf("Error when creating %s: %v", dir, err)
+			return "", fmt.Errorf("error when creating %s: %v", dir, err)
 		}
 	}
-	return filepath.Join(GetWorkspaceRoot(), WspConfigFolder, StampDirName)
+	return dir, nil
+}
+
+// GetPluginDirPath returns the path within the workspace config
+// folder that contains plugins, or "" outside a workspace
+func GetPluginDirPath() string {
+	wr := GetWorkspaceRoot()
+	if wr == "" {
+		return ""
+	}
+	return filepath.Join(wr, WspConfigFolder, PluginDirName)
+}
+
+// GetServicesFilePath returns the path to the file with the service
+// allocations of a running build, or "" outside a workspace
+func GetServicesFilePath() string {
+	wr := GetWorkspaceRoot()
+	if wr == "" {
+		return ""
+	}
+	return filepath.Join(wr, WspConfigFolder, ServicesFile)
 }
 
 // GetConfigFilePath returns the path to the config file within
@@ -95,38 +143,47 @@ func GetConfigFilePath() string {
 
 // Init initializes the environment package by loading variables from
 // the project.json file
-func Init() {
+func Init() error {
 	// GetWorkspaceRoot returns the root of the workspace
 	wspRoot := GetWorkspaceRoot()
 	if wspRoot == "" {
-
-		log.Fatalf("No %s directory found.", WspConfigFolder)
+		return fmt.Errorf("no %s directory found", WspConfigFolder)
 	}
 	configuration = new(Config)
 	configuration.Vars = make(map[string]string)
 	variables = make(map[string]string)
+	sources = make(map[string]Source)
 
-	projectFile := filepath.Join(wspRoot, WspConfigFolder, "config.json")
+	projectFile := filepath.Join(wspRoot, WspConfigFolder, ConfigFile)
 	raw, err := os.Open(projectFile)
-	defer raw.Close()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	defer raw.Close()
+	// An empty file, like one created by touch, is an empty configuration
+	if err := json.NewDecoder(raw).Decode(configuration); err != nil && err != io.EOF {
+		return fmt.Errorf("error reading %s: %v", projectFile, err)
+	}
+	if configuration.Vars == nil {
+		configuration.Vars = make(map[string]string)
 	}
-	json.NewDecoder(raw).Decode(configuration)
 
 	for k, v := range configuration.Vars {
 		variables[k] = v
+		sources[k] = FromConfig
 	}
 
 	// Set environment variables from os
 	for _, e := range os.Environ() {
 		pair := strings.Split(e, "=")
 		variables[pair[0]] = pair[1]
+		sources[pair[0]] = FromEnvironment
 	}
 
 	// Set automatic variables
 	variables["WORKSPACE"], _ = filepath.Abs(wspRoot)
-
+	sources["WORKSPACE"] = Automatic
+	return nil
 }
 
 // Get variable
@@ -135,22 +192,65 @@ func Get(k string) (string, bool) {
 	return v, err
 }
 
+// GetSource tells where the value of the variable comes from
+func GetSource(k string) (Source, bool) {
+	s, ok := sources[k]
+	return s, ok
+}
+
+// Vars returns the names of all variables, sorted
+func Vars() []string {
+	var res []string
+	for k := range variables {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
 // Configuration contains the config file
 func Configuration() *Config {
 	return configuration
 }
 
+// PinnedVersion returns the version constraint pinned for the
+// artifact name in the config file. Names are not case sensitive.
+func PinnedVersion(name string) (string, bool) {
+	if configuration == nil {
+		return "", false
+	}
+	for k, v := range configuration.Versions {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
 // DumpConfig dumps the config to the console
 func DumpConfig() {
 	res2B, _ := json.Marshal(Configuration())
 	fmt.Println(string(res2B))
 }
 
-// SetVar sets a variable
+// SetVar sets a variable. If perm is true it is also set
+// in the configuration, which is stored by SaveConfig.
 func SetVar(key, val string, perm bool) {
 	variables[key] = Resolve(val)
+	sources[key] = FromSetVar
 	if perm {
 		Configuration().Vars[key] = val
+		sources[key] = FromConfig
+	}
+}
+
+// UnsetVar removes a variable. If perm is true it is also
+// removed from the configuration, which is stored by SaveConfig.
+func UnsetVar(key string, perm bool) {
+	delete(variables, key)
+	delete(sources, key)
+	if perm {
+		delete(Configuration().Vars, key)
 	}
 }
 
@@ -178,7 +278,9 @@ func InitWorkspace(p string) error {
 	configuration = new(Config)
 	configuration.Vars = make(map[string]string)
 	variables = make(map[string]string)
+	sources = make(map[string]Source)
 	variables["WORKSPACE"] = p
+	sources["WORKSPACE"] = Automatic
 	return SaveConfig()
 }
 
@@ -198,6 +300,7 @@ func SaveConfig() error {
 	return enc.Encode(configuration)
 }
 
-func init() {
-	flag.StringVar(&WorkspaceRoot, "workspace", "", "Set the workspace root path.")
+// Flags registers the command line flags of the workspace package
+func Flags(fs *flag.FlagSet) {
+	fs.StringVar(&WorkspaceRoot, "workspace", "", "Set the workspace root path.")
 }